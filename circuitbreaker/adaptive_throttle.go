@@ -0,0 +1,132 @@
+package circuitbreaker
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/damnever/goctl/clock"
+)
+
+// ThrottleConfig configures the AdaptiveThrottle.
+type ThrottleConfig struct {
+	// CountWindow is the time window to keep stats, any stats before the window will be droped.
+	CountWindow time.Duration
+	// K is the multiplier of accepted requests, the lower the K, the more aggressive
+	// the throttling, 2 is a good choice, values less than 1 are treated as 1.
+	K float64
+	// MinRequests is the min number of requests in CountWindow to trigger the throttling.
+	MinRequests int
+	// IsFailure classifies the error returned in Run and traced by TraceErr,
+	// nil means DefaultIsFailure.
+	IsFailure func(err error) bool
+	// CoverPanic will recover the panic, only used in Run.
+	CoverPanic bool
+	// Clock is used to tell the time, nil means clock.New().
+	Clock clock.Clock
+}
+
+// DefaultThrottleConfig creates a default ThrottleConfig, it is just an example.
+func DefaultThrottleConfig() ThrottleConfig {
+	return ThrottleConfig{
+		CountWindow: 2 * time.Minute,
+		K:           2,
+		MinRequests: 10,
+		CoverPanic:  true,
+	}
+}
+
+// AdaptiveThrottle is an alternative to the CircuitBreaker, it implements the
+// client-side adaptive throttling from the Google SRE book, which rejects
+// requests with the probability:
+//
+//	max(0, (requests - K*accepts) / (requests + 1))
+//
+// the requests and accepts are counted in the CountWindow, so there is no
+// traffic cliff as the binary OPEN/CLOSE model does.
+type AdaptiveThrottle struct {
+	conf     ThrottleConfig
+	requests *rollingCounter
+	accepts  *rollingCounter
+}
+
+// NewAdaptiveThrottle creates a new AdaptiveThrottle.
+func NewAdaptiveThrottle(conf ThrottleConfig) *AdaptiveThrottle {
+	if conf.K < 1 {
+		conf.K = 1
+	}
+	if conf.IsFailure == nil {
+		conf.IsFailure = DefaultIsFailure
+	}
+	if conf.Clock == nil {
+		conf.Clock = clock.New()
+	}
+	now := conf.Clock.Now()
+	return &AdaptiveThrottle{
+		conf:     conf,
+		requests: newRollingCounter(conf.CountWindow, now),
+		accepts:  newRollingCounter(conf.CountWindow, now),
+	}
+}
+
+func (at *AdaptiveThrottle) rejectProbability(now time.Time) float64 {
+	requests := at.requests.Count(now)
+	if requests < at.conf.MinRequests {
+		return 0
+	}
+	accepts := at.accepts.Count(now)
+	return math.Max(0, (float64(requests)-at.conf.K*float64(accepts))/float64(requests+1))
+}
+
+// RejectProbability returns the current probability of rejecting a request.
+func (at *AdaptiveThrottle) RejectProbability() float64 {
+	return at.rejectProbability(at.conf.Clock.Now())
+}
+
+func (at *AdaptiveThrottle) admit() State {
+	now := at.conf.Clock.Now()
+	p := at.rejectProbability(now)
+	// The rejected requests are counted too, so the probability keeps
+	// growing if the backend keeps failing.
+	at.requests.Incr(now)
+	if p > 0 && rand.Float64() < p {
+		return StateOpen
+	}
+	return StateClosed
+}
+
+func (at *AdaptiveThrottle) trace(_ State, _ uint64, haserr bool, _ time.Time) {
+	if !haserr {
+		at.accepts.Incr(at.conf.Clock.Now())
+	}
+}
+
+func (at *AdaptiveThrottle) now() time.Time {
+	return at.conf.Clock.Now()
+}
+
+func (at *AdaptiveThrottle) isFailure(err error) bool {
+	return at.conf.IsFailure(err)
+}
+
+// Circuit creates a Circuit, each request(API call) requires exactly one Circuit, DO NOT reuse it or ignore it.
+// The Circuit is interrupted if the request is rejected.
+func (at *AdaptiveThrottle) Circuit() Circuit {
+	c := Circuit{
+		state:   at.admit(),
+		breaker: at,
+	}
+	if c.state != StateOpen {
+		c.startat = at.conf.Clock.Now()
+	}
+	return c
+}
+
+// Run is a shortcut for the workflow, ErrIsOpen returned if the request is rejected.
+func (at *AdaptiveThrottle) Run(fn func() error) error {
+	state := at.admit()
+	if state == StateOpen {
+		return ErrIsOpen
+	}
+	return run(at, at.conf.CoverPanic, state, 0, fn, at.isFailure)
+}
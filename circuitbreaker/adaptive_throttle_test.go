@@ -0,0 +1,61 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/damnever/goctl/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveThrottle(t *testing.T) {
+	conf := DefaultThrottleConfig()
+	conf.CountWindow = time.Minute
+	at := NewAdaptiveThrottle(conf)
+
+	for i := 0; i < 100; i++ {
+		require.Nil(t, at.Run(func() error { return nil }))
+	}
+	require.Equal(t, float64(0), at.RejectProbability())
+
+	// The backend starts to fail.
+	errFatal := errors.New("fatal")
+	rejected := 0
+	for i := 0; i < 1000; i++ {
+		c := at.Circuit()
+		if c.IsInterrupted() {
+			rejected++
+			continue
+		}
+		c.TraceErr(errFatal)
+	}
+	p := at.RejectProbability()
+	// (1100 - 2*100) / 1101
+	require.InDelta(t, 900.0/1101.0, p, 0.01)
+	require.True(t, rejected > 100, "%d", rejected)
+	require.True(t, rejected < 1000, "%d", rejected)
+
+	// No throttling before MinRequests.
+	at = NewAdaptiveThrottle(conf)
+	for i := 0; i < conf.MinRequests-1; i++ {
+		require.Equal(t, errFatal, at.Run(func() error { return errFatal }))
+	}
+	require.Equal(t, float64(0), at.RejectProbability())
+	require.Equal(t, errFatal, at.Run(func() error { return errFatal }))
+	require.InDelta(t, 10.0/11.0, at.RejectProbability(), 0.001)
+}
+
+func TestAdaptiveThrottleClock(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	conf := DefaultThrottleConfig()
+	conf.Clock = fake
+	at := NewAdaptiveThrottle(conf)
+
+	for i := 0; i < 100; i++ {
+		at.Circuit().Trace(true)
+	}
+	require.True(t, at.RejectProbability() > 0.9)
+	fake.Add(conf.CountWindow)
+	require.Equal(t, 0.0, at.RejectProbability())
+}
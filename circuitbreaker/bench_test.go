@@ -0,0 +1,78 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+)
+
+// Run with different GOMAXPROCS to see the scaling:
+//   go test -run=NONE -bench=. -cpu=1,2,4,8 ./circuitbreaker
+
+func BenchmarkCircuitClosed(b *testing.B) {
+	cb := New(DefaultConfig())
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c := cb.Circuit()
+			c.Trace(false)
+		}
+	})
+}
+
+func BenchmarkCircuitClosedWithFailures(b *testing.B) {
+	cb := New(DefaultConfig())
+	cb.Circuit().Trace(true)
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c := cb.Circuit()
+			// 1% failures, far below the ErrorRate.
+			i++
+			c.Trace(i%100 == 0)
+		}
+	})
+	if cb.State() != StateClosed {
+		b.Fatal("unexpected state")
+	}
+}
+
+func BenchmarkCircuitOpen(b *testing.B) {
+	conf := DefaultConfig()
+	conf.SleepWindow = time.Hour
+	cb := New(conf)
+	openAt(cb, time.Now())
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if c := cb.Circuit(); !c.IsInterrupted() {
+				c.Trace(false)
+			}
+		}
+	})
+}
+
+func BenchmarkRun(b *testing.B) {
+	cb := New(DefaultConfig())
+	fn := func() error { return nil }
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cb.Run(fn)
+		}
+	})
+}
+
+func BenchmarkRollingCounterIncr(b *testing.B) {
+	rc := newRollingCounter(20*time.Second, time.Now())
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rc.Incr(time.Now())
+		}
+	})
+}
+
+func BenchmarkRollingHistogramObserve(b *testing.B) {
+	rh := newRollingHistogram(20*time.Second, time.Now())
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rh.Observe(time.Now(), time.Millisecond)
+		}
+	})
+}
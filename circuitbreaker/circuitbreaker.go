@@ -3,195 +3,763 @@
 // The transformation of circuitbreaker's state is as follows:
 //  1. Assuming the number of requests reachs the threshold(Config.TriggerThreshold), initial state is CLOSE.
 //  2. After 1, if the error rate or the rate of high latency exceeds the threshold(Config.ErrorRate/HighLatencyRate),
-//     the circuitbreaker is OPEN.
+//     the circuitbreaker is OPEN. The condition can be replaced by Config.TripPolicy.
 //  3. Otherwise for 2, the circuitbreaker will be CLOSE.
-//  4. After 2, the circuitbreaker remains OPEN until some amount of time(Config.SleepWindow) pass,
-//     then it's the HALF-OPEN which let a single request through, if the request fails, it returns to
-//     the OPEN. If the request succeeds, the circuitbreaker is CLOSE, and 1 takes over again.
+//  4. After 2, the circuitbreaker remains OPEN until some amount of time(Config.SleepWindow, which may
+//     grow exponentially on consecutive failed probes by Config.SleepWindowMultiplier) pass,
+//     then it's the HALF-OPEN which let limited requests(Config.HalfOpenMaxProbes) through concurrently,
+//     if any of the requests fails, it returns to the OPEN. If enough consecutive requests
+//     (Config.HalfOpenSuccesses) succeed, the circuitbreaker is CLOSE, and 1 takes over again.
+//  5. After 4, if Config.RecoveryDuration is set, the admitted fraction of requests rises
+//     from 0 to 1 by Config.RecoveryRamp during the RecoveryDuration, the rest are rejected,
+//     and the stats which opened the circuitbreaker are cleared.
 package circuitbreaker
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
+	"math/rand"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
-)
 
-// FIXME(damnever): the two-phase lock may be problematic..
+	"github.com/damnever/goctl/clock"
+)
 
 // ErrIsOpen means circuitbreaker is open, the system or API is not healthy.
 var ErrIsOpen = errors.New("circuitbreaker is open: not healthy")
 
-type cbState uint8
+// State is the state of CircuitBreaker.
+type State uint8
 
 const (
-	cbClose cbState = iota
-	cbHalfOpen
-	cbOpen
+	// StateClosed means requests are allowed.
+	StateClosed State = iota
+	// StateHalfOpen means limited requests are allowed to probe the health.
+	StateHalfOpen
+	// StateOpen means requests are rejected.
+	StateOpen
 )
 
+// String returns the name of the state.
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(s))
+	}
+}
+
+// Ramp is the shape of the recovery ramp after the circuitbreaker is CLOSE from HALF-OPEN.
+type Ramp uint8
+
+const (
+	// RampLinear admits the fraction of requests which rises linearly.
+	RampLinear Ramp = iota
+	// RampExponential admits the fraction of requests which doubles every 1/10
+	// of the RecoveryDuration, it starts from about 0.1% of requests.
+	RampExponential
+)
+
+// fraction returns the admitted fraction of requests at x(in [0, 1]) of the ramp.
+func (r Ramp) fraction(x float64) float64 {
+	switch r {
+	case RampExponential:
+		return math.Exp2(10 * (x - 1))
+	default:
+		return x
+	}
+}
+
 // Config configures the CircuitBreaker.
 type Config struct {
 	// TriggerThreshold is the threshold of total requests to trigger the circuitbreaker,
-	// 0 means never trigger.
+	// 0 means never trigger if TripPolicy is nil.
 	TriggerThreshold int
 	// CountWindow is the time window to keep stats, any stats before the window will be droped.
 	CountWindow time.Duration
 	// SleepWindow is the wait time before recovering.
 	SleepWindow time.Duration
+	// SleepWindowMultiplier grows the SleepWindow exponentially on each consecutive
+	// failed HALF-OPEN, so a dead system will not be probed too often,
+	// the SleepWindow is reset once CLOSE, values less than or equal to 1 mean no growth.
+	SleepWindowMultiplier float64
+	// MaxSleepWindow is the max value of the grown and jittered SleepWindow, 0 means no limit.
+	MaxSleepWindow time.Duration
+	// SleepWindowJitter randomizes the SleepWindow by [-SleepWindowJitter, SleepWindowJitter)
+	// of itself to avoid the synchronized probes, it must be in [0, 1).
+	SleepWindowJitter float64
 	// ErrorRate is the error rate to trigger the circuitbreaker.
 	ErrorRate float64
 	// HighLatencyValue is the max acceptable latency.
 	HighLatencyValue time.Duration
 	// HighLatencyRate is the rate for HighLatencyValue to trigger the circuitbreaker.
 	HighLatencyRate float64
-	// CoverPanic will recover the panic, only used in Run.
+	// Timeout is the timeout for the fn in RunCtx, 0 means HighLatencyValue.
+	Timeout time.Duration
+	// TripPolicy decides when to trigger the circuitbreaker, nil means
+	// RateTripPolicy(TriggerThreshold, ErrorRate, HighLatencyRate).
+	TripPolicy TripPolicy
+	// HalfOpenMaxProbes is the max number of concurrent requests allowed in HALF-OPEN, 0 means 1.
+	HalfOpenMaxProbes int
+	// HalfOpenSuccesses is the number of consecutive successful requests required
+	// to close the circuitbreaker in HALF-OPEN, 0 means 1.
+	HalfOpenSuccesses int
+	// IsFailure classifies the error returned in Run and traced by TraceErr,
+	// only the error which it returns true will be counted as failure,
+	// nil means DefaultIsFailure.
+	IsFailure func(err error) bool
+	// CoverPanic will recover the panic, only used in Run and RunCtx.
 	CoverPanic bool
+	// RecoveryDuration is the duration of slow-start after the circuitbreaker is CLOSE
+	// from HALF-OPEN, it gives the recovering system time to warm up, 0 means no slow-start.
+	// The stats are cleared once CLOSE if it is set, so the slow-start is not tripped by the
+	// failures which opened the circuitbreaker.
+	RecoveryDuration time.Duration
+	// RecoveryRamp is the shape of the slow-start, RampLinear by default.
+	RecoveryRamp Ramp
+	// Clock is used to tell the time, nil means clock.New(), the timeout of
+	// RunCtx always uses the real time since it is driven by the context.
+	Clock clock.Clock
+	// OnStateChange is called after the state changed if not nil, it is called
+	// outside the lock, so the order of calls from different goroutines is not
+	// guaranteed, and it must not block for long since it blocks the caller.
+	OnStateChange func(from, to State)
 }
 
 // DefaultConfig creates a default Config, it is just an example.
 func DefaultConfig() Config {
 	return Config{
-		TriggerThreshold: 10,
-		CountWindow:      20 * time.Second,
-		SleepWindow:      666 * time.Millisecond,
-		ErrorRate:        0.3,
-		HighLatencyValue: time.Second,
-		HighLatencyRate:  0.5,
-		CoverPanic:       true,
+		TriggerThreshold:  10,
+		CountWindow:       20 * time.Second,
+		SleepWindow:       666 * time.Millisecond,
+		ErrorRate:         0.3,
+		HighLatencyValue:  time.Second,
+		HighLatencyRate:   0.5,
+		HalfOpenMaxProbes: 1,
+		HalfOpenSuccesses: 1,
+		CoverPanic:        true,
+	}
+}
+
+// ConfigError is returned by Config.Validate if a field of the Config is invalid.
+type ConfigError struct {
+	// Field is the name of the invalid field.
+	Field  string
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return "circuitbreaker: invalid Config." + e.Field + ": " + e.Reason
+}
+
+// Validate checks the Config, a *ConfigError returned if any field is invalid.
+// The Config which disables the circuitbreaker(TripPolicy is nil and
+// TriggerThreshold is 0) is always valid.
+func (conf Config) Validate() error {
+	invalid := func(field, reason string) error {
+		return &ConfigError{Field: field, Reason: reason}
+	}
+	if conf.TriggerThreshold < 0 {
+		return invalid("TriggerThreshold", "must be greater than or equal to 0")
+	}
+	if conf.TripPolicy == nil {
+		if conf.TriggerThreshold == 0 {
+			return nil
+		}
+		if conf.ErrorRate <= 0 || conf.ErrorRate > 1 {
+			return invalid("ErrorRate", "must be in (0, 1]")
+		}
+		if conf.HighLatencyRate <= 0 || conf.HighLatencyRate > 1 {
+			return invalid("HighLatencyRate", "must be in (0, 1]")
+		}
 	}
+
+	switch {
+	case conf.CountWindow <= 0:
+		return invalid("CountWindow", "must be greater than 0")
+	case conf.SleepWindow < 0:
+		return invalid("SleepWindow", "must be greater than or equal to 0")
+	case conf.SleepWindowMultiplier < 0:
+		return invalid("SleepWindowMultiplier", "must be greater than or equal to 0")
+	case conf.MaxSleepWindow < 0:
+		return invalid("MaxSleepWindow", "must be greater than or equal to 0")
+	case conf.MaxSleepWindow > 0 && conf.MaxSleepWindow < conf.SleepWindow:
+		return invalid("MaxSleepWindow", "must be greater than or equal to SleepWindow")
+	case conf.SleepWindowJitter < 0 || conf.SleepWindowJitter >= 1:
+		return invalid("SleepWindowJitter", "must be in [0, 1)")
+	case conf.HighLatencyValue <= 0:
+		return invalid("HighLatencyValue", "must be greater than 0")
+	case conf.Timeout < 0:
+		return invalid("Timeout", "must be greater than or equal to 0")
+	case conf.HalfOpenMaxProbes < 0:
+		return invalid("HalfOpenMaxProbes", "must be greater than or equal to 0")
+	case conf.HalfOpenSuccesses < 0:
+		return invalid("HalfOpenSuccesses", "must be greater than or equal to 0")
+	case conf.RecoveryDuration < 0:
+		return invalid("RecoveryDuration", "must be greater than or equal to 0")
+	case conf.RecoveryRamp != RampLinear && conf.RecoveryRamp != RampExponential:
+		return invalid("RecoveryRamp", "unknown ramp")
+	}
+	return nil
+}
+
+// DefaultIsFailure treats all non-nil errors as failure except the context.Canceled,
+// since the cancellation by the caller tells nothing about the health.
+func DefaultIsFailure(err error) bool {
+	return err != nil && !errors.Is(err, context.Canceled)
 }
 
 // CircuitBreaker traces the failures then protects the system.
 //
-// There is a lock inside, in case of you worry about the performance,
-// use multiple CircuitBreaker for defferent kinds of API(request type),
+// The hot path is lock-free: the state is read with atomic operations and the
+// stats are recorded by sharded atomic counters, the lock is only held on
+// state transitions and in HALF-OPEN.
 type CircuitBreaker struct {
-	disabled bool
-	conf     Config
+	// Accessed atomically, keep them at the top for 64-bit alignment.
+	openAt     int64 // Nanoseconds since base.
+	lastFailAt int64 // Nanoseconds since base.
+	failures   int64 // Consecutive failures.
+	evalAt     int64 // Nanoseconds since base, the last time TripPolicy evaluated.
+	pollAt     int64 // Nanoseconds since base, the last time TripPolicy polled with failures in the window.
+	sleep      int64 // The current SleepWindow.
+	closedAt   int64 // Nanoseconds since base, the last time CLOSE from HALF-OPEN.
+	state      uint32
+	forced     uint32 // 0 means not forced, otherwise State+1.
+
+	disabled  bool
+	conf      Config
+	clock     clock.Clock
+	base      time.Time
+	total     *rollingCounter
+	errors    *rollingCounter
+	timeouts  *rollingCounter
+	latencies *rollingHistogram
 
-	l        sync.Mutex
-	total    *rollingCounter
-	errors   *rollingCounter
-	timeouts *rollingCounter
-	state    cbState
-	openAt   time.Time
+	l   sync.Mutex
+	rnd *rand.Rand
+	// The generation of HALF-OPEN, used to drop the stale probes.
+	gen       uint64
+	probes    int
+	successes int
+	reopens   int // The number of consecutive failed HALF-OPEN.
 }
 
-// New creates a new CircuitBreaker. It doesn't check the Config for the caller.
+// New creates a new CircuitBreaker. It doesn't check the Config for the caller,
+// use NewE or Config.Validate for that. The window which is too small to be split
+// into 10 buckets(1ms at least) is split into fewer buckets.
 func New(conf Config) *CircuitBreaker {
-	if conf.TriggerThreshold <= 0 {
-		return &CircuitBreaker{disabled: true}
+	if conf.Clock == nil {
+		conf.Clock = clock.New()
+	}
+	if conf.IsFailure == nil {
+		conf.IsFailure = DefaultIsFailure
+	}
+	if conf.TripPolicy == nil {
+		if conf.TriggerThreshold <= 0 {
+			// Keep the conf, the Timeout, CoverPanic, etc. still work.
+			return &CircuitBreaker{disabled: true, conf: conf, clock: conf.Clock}
+		}
+		conf.TripPolicy = RateTripPolicy(conf.TriggerThreshold, conf.ErrorRate, conf.HighLatencyRate)
 	}
-	now := time.Now()
+	if conf.HalfOpenMaxProbes <= 0 {
+		conf.HalfOpenMaxProbes = 1
+	}
+	if conf.HalfOpenSuccesses <= 0 {
+		conf.HalfOpenSuccesses = 1
+	}
+	now := conf.Clock.Now()
 	return &CircuitBreaker{
-		conf:     conf,
-		total:    newRollingCounter(conf.CountWindow, now),
-		errors:   newRollingCounter(conf.CountWindow, now),
-		timeouts: newRollingCounter(conf.CountWindow, now),
+		conf:       conf,
+		clock:      conf.Clock,
+		base:       now,
+		lastFailAt: -int64(conf.CountWindow),
+		pollAt:     -int64(minPollInterval),
+		closedAt:   -int64(conf.RecoveryDuration),
+		sleep:      int64(conf.SleepWindow),
+		rnd:        rand.New(rand.NewSource(now.UnixNano())),
+		total:      newRollingCounter(conf.CountWindow, now),
+		errors:     newRollingCounter(conf.CountWindow, now),
+		timeouts:   newRollingCounter(conf.CountWindow, now),
+		latencies:  newRollingHistogram(conf.CountWindow, now),
 	}
 }
 
-func (cb *CircuitBreaker) currentState() cbState {
-	if cb.disabled {
-		return cbClose
+// NewE is like New, but returns the error if the Config is invalid.
+func NewE(conf Config) (*CircuitBreaker, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
 	}
+	return New(conf), nil
+}
 
-	now := time.Now()
-	cb.l.Lock()
-	defer cb.l.Unlock()
+type transition struct {
+	from State
+	to   State
+}
+
+func (cb *CircuitBreaker) notify(t transition) {
+	if t.from != t.to && cb.conf.OnStateChange != nil {
+		cb.conf.OnStateChange(t.from, t.to)
+	}
+}
 
-	switch cb.state {
-	case cbClose:
-		if totalint := cb.total.Count(now); totalint >= cb.conf.TriggerThreshold {
-			total := float64(totalint)
-			if float64(cb.errors.Count(now))/total >= cb.conf.ErrorRate ||
-				float64(cb.timeouts.Count(now))/total >= cb.conf.HighLatencyRate {
-				cb.state = cbOpen
-				cb.openAt = now
+// nanos converts the t to the monotonic nanoseconds since base.
+func (cb *CircuitBreaker) nanos(t time.Time) int64 {
+	return int64(t.Sub(cb.base))
+}
+
+func (cb *CircuitBreaker) loadState() State {
+	return State(atomic.LoadUint32(&cb.state))
+}
+
+// setState must be called with the lock held.
+func (cb *CircuitBreaker) setState(state State, now time.Time) {
+	switch state {
+	case StateOpen:
+		if cb.loadState() == StateHalfOpen {
+			cb.reopens++
+		} else {
+			cb.reopens = 0
+		}
+		atomic.StoreInt64(&cb.sleep, int64(cb.sleepWindow()))
+		atomic.StoreInt64(&cb.openAt, cb.nanos(now))
+	case StateClosed:
+		cb.reopens = 0
+		closedAt := -int64(cb.conf.RecoveryDuration)
+		if cb.loadState() == StateHalfOpen {
+			closedAt = cb.nanos(now)
+			if cb.conf.RecoveryDuration > 0 {
+				// The stats which opened it are stale, otherwise it will be
+				// tripped by them again during the slow-start.
+				cb.resetStats()
 			}
 		}
-	case cbHalfOpen:
-		// Ensure only one request get passed.
-		return cbOpen
-	case cbOpen:
-		if cb.openAt.IsZero() {
-			panic("circuitbreaker: corrupted")
+		atomic.StoreInt64(&cb.closedAt, closedAt)
+	}
+	atomic.StoreUint32(&cb.state, uint32(state))
+}
+
+// sleepWindow must be called with the lock held.
+func (cb *CircuitBreaker) sleepWindow() time.Duration {
+	window := float64(cb.conf.SleepWindow)
+	if cb.conf.SleepWindowMultiplier > 1 {
+		window *= math.Pow(cb.conf.SleepWindowMultiplier, float64(cb.reopens))
+	}
+	if jitter := cb.conf.SleepWindowJitter; jitter > 0 {
+		window *= 1 + jitter*(2*cb.rnd.Float64()-1)
+	}
+	// Clamp after the jitter, the MaxSleepWindow is a hard limit.
+	if max := float64(cb.conf.MaxSleepWindow); max > 0 && window > max {
+		window = max
+	}
+	return time.Duration(window)
+}
+
+func (cb *CircuitBreaker) sleepUntil() int64 {
+	return atomic.LoadInt64(&cb.openAt) + atomic.LoadInt64(&cb.sleep)
+}
+
+func (cb *CircuitBreaker) currentState() State {
+	state, _ := cb.admit()
+	return state
+}
+
+// admit returns the state for a new request and the generation of HALF-OPEN.
+func (cb *CircuitBreaker) admit() (State, uint64) {
+	if state, ok := cb.Forced(); ok {
+		return state, 0
+	}
+	if cb.disabled {
+		return StateClosed, 0
+	}
+
+	now := cb.clock.Now()
+	switch cb.loadState() {
+	case StateClosed:
+		t := cb.tryTrip(now)
+		cb.notify(t)
+		if t.to == StateOpen || !cb.recovered(now) {
+			return StateOpen, 0
+		}
+		return StateClosed, 0
+	case StateOpen:
+		if cb.nanos(now) <= cb.sleepUntil() {
+			return StateOpen, 0
 		}
-		if now.After(cb.openAt.Add(cb.conf.SleepWindow)) {
-			cb.state = cbHalfOpen
+	}
+
+	state, gen, t := cb.checkState(now)
+	cb.notify(t)
+	return state, gen
+}
+
+// recovered returns false if the request is rejected by the slow-start.
+func (cb *CircuitBreaker) recovered(now time.Time) bool {
+	duration := int64(cb.conf.RecoveryDuration)
+	if duration <= 0 {
+		return true
+	}
+	elapsed := cb.nanos(now) - atomic.LoadInt64(&cb.closedAt)
+	if elapsed >= duration {
+		return true
+	}
+	return rand.Float64() < cb.conf.RecoveryRamp.fraction(float64(elapsed)/float64(duration))
+}
+
+func (cb *CircuitBreaker) checkState(now time.Time) (State, uint64, transition) {
+	cb.l.Lock()
+	defer cb.l.Unlock()
+
+	from := cb.loadState()
+	switch from {
+	case StateClosed:
+		return StateClosed, 0, transition{}
+	case StateHalfOpen:
+	case StateOpen:
+		if cb.nanos(now) <= cb.sleepUntil() {
+			return StateOpen, 0, transition{}
 		}
+		cb.gen++
+		cb.probes = 0
+		cb.successes = 0
+		cb.setState(StateHalfOpen, now)
+	}
+
+	// Ensure only limited requests get passed.
+	t := transition{from: from, to: StateHalfOpen}
+	if cb.probes >= cb.conf.HalfOpenMaxProbes {
+		return StateOpen, cb.gen, t
 	}
-	return cb.state
+	cb.probes++
+	return StateHalfOpen, cb.gen, t
 }
 
-func (cb *CircuitBreaker) trace(prevstate cbState, haserr bool, start time.Time) {
+func (cb *CircuitBreaker) counts(now time.Time) Counts {
+	return Counts{
+		Requests:            cb.total.Count(now),
+		Errors:              cb.errors.Count(now),
+		Timeouts:            cb.timeouts.Count(now),
+		ConsecutiveFailures: int(atomic.LoadInt64(&cb.failures)),
+		latencies:           cb.latencies,
+		at:                  now,
+	}
+}
+
+func (cb *CircuitBreaker) trace(prevstate State, gen uint64, haserr bool, start time.Time) {
 	if cb.disabled {
 		return
 	}
-	if prevstate == cbOpen {
+	if prevstate == StateOpen {
 		panic("circuitbreaker: corrupted")
 	}
+	cb.notify(cb.record(prevstate, gen, haserr, start, cb.clock.Now()))
+}
 
+func (cb *CircuitBreaker) record(prevstate State, gen uint64, haserr bool, start, now time.Time) transition {
 	isok := true
-	now := time.Now()
-	cb.l.Lock()
-	defer cb.l.Unlock()
-
+	latency := now.Sub(start)
 	cb.total.Incr(now)
+	cb.latencies.Observe(now, latency)
 	if haserr {
 		cb.errors.Incr(now)
 		isok = false
 	}
-	if now.Sub(start) >= cb.conf.HighLatencyValue {
+	if latency >= cb.conf.HighLatencyValue {
 		cb.timeouts.Incr(now)
 		isok = false
 	}
-
-	// For the two step locks..
-	if prevstate == cbHalfOpen {
-		if cb.state != cbHalfOpen {
-			panic("circuitbreaker: corrupted")
+	if isok {
+		// Avoid writing the shared memory if possible.
+		if atomic.LoadInt64(&cb.failures) != 0 {
+			atomic.StoreInt64(&cb.failures, 0)
 		}
-		if isok {
-			cb.state = cbClose
-		} else {
-			cb.state = cbOpen
-			cb.openAt = now
+	} else {
+		atomic.AddInt64(&cb.failures, 1)
+		atomic.StoreInt64(&cb.lastFailAt, cb.nanos(now))
+	}
+
+	if prevstate == StateHalfOpen {
+		return cb.probed(gen, isok, now)
+	}
+	return transition{}
+}
+
+func (cb *CircuitBreaker) tryTrip(now time.Time) transition {
+	if cb.loadState() != StateClosed || !cb.shouldEvaluate(now) {
+		return transition{}
+	}
+	if !cb.conf.TripPolicy.ShouldTrip(cb.counts(now)) {
+		return transition{}
+	}
+
+	cb.l.Lock()
+	defer cb.l.Unlock()
+	if cb.loadState() != StateClosed { // Double check.
+		return transition{}
+	}
+	cb.setState(StateOpen, now)
+	return transition{from: StateClosed, to: StateOpen}
+}
+
+// minPollInterval bounds how often the TripPolicy is evaluated if there are
+// failures in the CountWindow but no new ones.
+const minPollInterval = time.Millisecond
+
+// shouldEvaluate returns true if there are new failures since the last
+// evaluation, or at most once per minPollInterval if there are failures in
+// the CountWindow, otherwise at most once per bucket interval, so the cost is
+// bounded by the failures rather than the requests.
+func (cb *CircuitBreaker) shouldEvaluate(now time.Time) bool {
+	nanos := cb.nanos(now)
+	last := atomic.LoadInt64(&cb.evalAt)
+	lastFailAt := atomic.LoadInt64(&cb.lastFailAt)
+	if lastFailAt >= last || nanos-last >= int64(cb.total.interval) {
+		return atomic.CompareAndSwapInt64(&cb.evalAt, last, nanos)
+	}
+	if nanos-lastFailAt >= int64(cb.conf.CountWindow) {
+		return false
+	}
+	poll := atomic.LoadInt64(&cb.pollAt)
+	return nanos-poll >= int64(minPollInterval) && atomic.CompareAndSwapInt64(&cb.pollAt, poll, nanos)
+}
+
+func (cb *CircuitBreaker) probed(gen uint64, isok bool, now time.Time) transition {
+	cb.l.Lock()
+	defer cb.l.Unlock()
+
+	// The probe may be stale since other probes may have changed the state.
+	if cb.loadState() != StateHalfOpen || gen != cb.gen {
+		return transition{}
+	}
+	cb.probes--
+	to := StateOpen
+	if isok {
+		if cb.successes++; cb.successes < cb.conf.HalfOpenSuccesses {
+			return transition{}
 		}
+		to = StateClosed
 	}
+	cb.setState(to, now)
+	return transition{from: StateHalfOpen, to: to}
+}
+
+// ForceOpen forces the CircuitBreaker to be OPEN regardless of the stats,
+// until ForceClosed or Reset called.
+func (cb *CircuitBreaker) ForceOpen() {
+	atomic.StoreUint32(&cb.forced, uint32(StateOpen)+1)
+}
+
+// ForceClosed forces the CircuitBreaker to be CLOSE regardless of the stats,
+// until ForceOpen or Reset called, the stats are still recorded.
+func (cb *CircuitBreaker) ForceClosed() {
+	atomic.StoreUint32(&cb.forced, uint32(StateClosed)+1)
+}
+
+// Forced returns the forced state and true if the CircuitBreaker is forced
+// by ForceOpen or ForceClosed, otherwise returns false.
+func (cb *CircuitBreaker) Forced() (State, bool) {
+	forced := atomic.LoadUint32(&cb.forced)
+	if forced == 0 {
+		return StateClosed, false
+	}
+	return State(forced - 1), true
+}
+
+// Reset removes the forced state, clears all the stats and sets
+// the state to CLOSE without slow-start.
+func (cb *CircuitBreaker) Reset() {
+	atomic.StoreUint32(&cb.forced, 0)
+	if cb.disabled {
+		return
+	}
+
+	cb.l.Lock()
+	from := cb.loadState()
+	cb.gen++ // Drop all the probes.
+	cb.probes = 0
+	cb.successes = 0
+	cb.resetStats()
+	cb.setState(StateClosed, cb.clock.Now())
+	atomic.StoreInt64(&cb.closedAt, -int64(cb.conf.RecoveryDuration)) // No slow-start.
+	cb.l.Unlock()
+
+	cb.notify(transition{from: from, to: StateClosed})
+}
+
+// resetStats must be called with the lock held.
+func (cb *CircuitBreaker) resetStats() {
+	cb.total.Reset()
+	cb.errors.Reset()
+	cb.timeouts.Reset()
+	cb.latencies.Reset()
+	atomic.StoreInt64(&cb.failures, 0)
+	atomic.StoreInt64(&cb.lastFailAt, -int64(cb.conf.CountWindow))
+}
+
+// State returns the current state of the CircuitBreaker, which is driven by
+// the stats, it doesn't respect the forced state, use Forced for that.
+func (cb *CircuitBreaker) State() State {
+	if cb.disabled {
+		return StateClosed
+	}
+	return cb.loadState()
+}
+
+// Counts returns the current stats of requests.
+func (cb *CircuitBreaker) Counts() Counts {
+	if cb.disabled {
+		return Counts{}
+	}
+	return cb.counts(cb.clock.Now())
+}
+
+// Metrics is a snapshot of the CircuitBreaker.
+type Metrics struct {
+	// State is the state driven by the stats.
+	State State
+	// Forced indicates the State is overridden by ForcedState.
+	Forced      bool
+	ForcedState State
+	// OpenAt is the time of the last OPEN, it is zero if the State is CLOSE.
+	OpenAt time.Time
+	Counts Counts
+	// The percentiles of latency in the CountWindow.
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+}
+
+// Metrics returns a snapshot of the CircuitBreaker.
+func (cb *CircuitBreaker) Metrics() Metrics {
+	m := Metrics{State: StateClosed}
+	m.ForcedState, m.Forced = cb.Forced()
+	if cb.disabled {
+		return m
+	}
+	now := cb.clock.Now()
+	dist := cb.latencies.Distribution(now)
+	m.State = cb.loadState()
+	if m.State != StateClosed {
+		m.OpenAt = cb.base.Add(time.Duration(atomic.LoadInt64(&cb.openAt)))
+	}
+	m.Counts = cb.counts(now)
+	m.P50 = percentile(dist, 0.5)
+	m.P90 = percentile(dist, 0.9)
+	m.P99 = percentile(dist, 0.99)
+	return m
 }
 
 // Circuit creates a Circuit, each request(API call) requires exactly one Circuit, DO NOT reuse it or ignore it.
 // You can use Run for "convenience".
 func (cb *CircuitBreaker) Circuit() Circuit {
+	state, gen := cb.admit()
 	c := Circuit{
-		state:   cb.currentState(),
+		state:   state,
+		gen:     gen,
 		breaker: cb,
 	}
-	if c.state != cbOpen {
-		c.startat = time.Now()
+	if c.state != StateOpen {
+		c.startat = cb.clock.Now()
 	}
 	return c
 }
 
-// Run is a shortcut for the workflow.
+func (cb *CircuitBreaker) now() time.Time {
+	return cb.clock.Now()
+}
+
+func (cb *CircuitBreaker) isFailure(err error) bool {
+	return cb.conf.IsFailure(err)
+}
+
+// Run is a shortcut for the workflow, the error returned by fn is classified by Config.IsFailure.
 func (cb *CircuitBreaker) Run(fn func() error) (err error) {
-	state := cb.currentState()
-	if state == cbOpen {
+	state, gen := cb.admit()
+	if state == StateOpen {
 		err = ErrIsOpen
 		return
 	}
+	return run(cb, cb.conf.CoverPanic, state, gen, fn, cb.isFailure)
+}
 
+// RunCtx is like Run, but the ctx passed to fn will be canceled after Config.Timeout,
+// the fn should respect the ctx, the timeout is always counted as failure, while the
+// error is never counted as failure once the ctx itself is canceled or timed out.
+// The fallback(if not nil) will be called with the original ctx if the circuitbreaker is
+// OPEN(ErrIsOpen) or fn fails(classified by Config.IsFailure, or timed out),
+// the result of fallback is returned.
+func (cb *CircuitBreaker) RunCtx(
+	ctx context.Context,
+	fn func(ctx context.Context) error,
+	fallback func(ctx context.Context, err error) error,
+) (err error) {
+	isFailure := cb.isFailure
+	defer func() {
+		if fallback != nil && (err == ErrIsOpen || (err != nil && isFailure(err))) {
+			err = fallback(ctx, err)
+		}
+	}()
+
+	state, gen := cb.admit()
+	if state == StateOpen {
+		err = ErrIsOpen
+		return
+	}
+
+	fnctx := ctx
+	timeout := cb.conf.Timeout
+	if timeout <= 0 {
+		timeout = cb.conf.HighLatencyValue
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		fnctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	isFailure = func(err error) bool {
+		if err == nil {
+			return false
+		}
+		if ctx.Err() != nil {
+			return false // Canceled or timed out by the caller, not the fault of the backend.
+		}
+		if fnctx.Err() == context.DeadlineExceeded {
+			return true // Timed out by us.
+		}
+		return cb.isFailure(err)
+	}
+	return run(cb, cb.conf.CoverPanic, state, gen, func() error { return fn(fnctx) }, isFailure)
+}
+
+// tracer traces the result of Circuit.
+type tracer interface {
+	now() time.Time
+	trace(state State, gen uint64, haserr bool, start time.Time)
+	isFailure(err error) bool
+}
+
+func run(
+	t tracer, coverPanic bool,
+	state State, gen uint64,
+	fn func() error, isFailure func(error) bool,
+) (err error) {
 	defer func(start time.Time) {
-		if cb.conf.CoverPanic {
+		if coverPanic {
 			if perr := recover(); perr != nil {
 				err = fmt.Errorf("%v: %s", perr, debug.Stack())
 			}
 		}
-		cb.trace(state, err != nil, start)
-	}(time.Now())
+		t.trace(state, gen, isFailure(err), start)
+	}(t.now())
 
 	err = fn()
 	return
@@ -199,23 +767,33 @@ func (cb *CircuitBreaker) Run(fn func() error) (err error) {
 
 // Circuit is used for every call.
 type Circuit struct {
-	state   cbState
+	state   State
+	gen     uint64
 	startat time.Time
-	breaker *CircuitBreaker
+	breaker tracer
 }
 
 // IsInterrupted returns true if the circuit is interrupted, the caller should return immediately.
 // Otherwise, returns false.
 func (c Circuit) IsInterrupted() bool {
-	return c.state == cbOpen
+	return c.state == StateOpen
 }
 
-// Trace records the stats, the caller shouldn't record the "tolerable" error.
+// Trace records the stats, the caller shouldn't record the "tolerable" error,
+// or use TraceErr to classify the error by Config.IsFailure.
 // If IsInterrupted returns false, the caller must(use defer) Trace the result,
 // otherwise, the circuitbreaker may be OPEN forever.
 func (c Circuit) Trace(haserr bool) {
-	if c.state == cbOpen {
+	if c.state == StateOpen {
+		return
+	}
+	c.breaker.trace(c.state, c.gen, haserr, c.startat)
+}
+
+// TraceErr is like Trace, but the err is classified by Config.IsFailure.
+func (c Circuit) TraceErr(err error) {
+	if c.state == StateOpen {
 		return
 	}
-	c.breaker.trace(c.state, haserr, c.startat)
+	c.breaker.trace(c.state, c.gen, c.breaker.isFailure(err), c.startat)
 }
@@ -1,84 +1,97 @@
 package circuitbreaker
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/damnever/goctl/clock"
 	"github.com/stretchr/testify/require"
 )
 
-var testconf = Config{
-	TriggerThreshold: 10,
-	CountWindow:      time.Second,
-	SleepWindow:      500 * time.Millisecond,
-	ErrorRate:        0.5,
-	HighLatencyValue: time.Second,
-	HighLatencyRate:  0.5,
-	CoverPanic:       true,
+var testconf = newTestConfig()
+
+// newTestConfig creates a fresh Config for the tests which modify it,
+// the package-level testconf must stay untouched.
+func newTestConfig() Config {
+	return Config{
+		TriggerThreshold: 10,
+		CountWindow:      time.Second,
+		SleepWindow:      500 * time.Millisecond,
+		ErrorRate:        0.5,
+		HighLatencyValue: time.Second,
+		HighLatencyRate:  0.5,
+		CoverPanic:       true,
+	}
+}
+
+func openAt(cb *CircuitBreaker, at time.Time) {
+	cb.l.Lock()
+	defer cb.l.Unlock()
+	cb.setState(StateOpen, at)
 }
 
 func TestCircuitBreakerCloseToOpen(t *testing.T) {
 	cb := New(testconf)
-	require.Equal(t, cbClose, cb.currentState())
+	require.Equal(t, StateClosed, cb.currentState())
 
 	now := time.Now()
 	for i := 0; i < testconf.TriggerThreshold-1; i++ {
-		cb.trace(cbClose, true, now)
+		cb.trace(StateClosed, 0, true, now)
 	}
-	require.Equal(t, cbClose, cb.currentState())
-	cb.trace(cbClose, false, now)
-	require.Equal(t, cbOpen, cb.currentState())
+	require.Equal(t, StateClosed, cb.currentState())
+	cb.trace(StateClosed, 0, false, now)
+	require.Equal(t, StateOpen, cb.currentState())
 }
 
 func TestCircuitBreakerOpenSleepToHalfOpen(t *testing.T) {
 	cb := New(testconf)
-	cb.state = cbOpen
-	cb.openAt = time.Now().Add(-testconf.SleepWindow + 10*time.Millisecond)
-	require.Equal(t, cbOpen, cb.currentState())
+	openAt(cb, time.Now().Add(-testconf.SleepWindow+10*time.Millisecond))
+	require.Equal(t, StateOpen, cb.currentState())
 	time.Sleep(10 * time.Millisecond)
-	require.Equal(t, cbHalfOpen, cb.currentState())
+	require.Equal(t, StateHalfOpen, cb.currentState())
 }
 
 func TestCircuitBreakerHalfOpenToOpen(t *testing.T) {
 	cb := New(testconf)
-	cb.state = cbOpen
-	cb.openAt = time.Now().Add(-testconf.SleepWindow + 2*time.Millisecond)
-	require.Equal(t, cbOpen, cb.currentState())
+	openAt(cb, time.Now().Add(-testconf.SleepWindow+2*time.Millisecond))
+	require.Equal(t, StateOpen, cb.currentState())
 	time.Sleep(2 * time.Millisecond)
-	require.Equal(t, cbHalfOpen, cb.currentState())
-	cb.trace(cbHalfOpen, false, time.Now().Add(-testconf.HighLatencyValue))
-	require.Equal(t, cbOpen, cb.currentState())
+	require.Equal(t, StateHalfOpen, cb.currentState())
+	cb.trace(StateHalfOpen, cb.gen, false, time.Now().Add(-testconf.HighLatencyValue))
+	require.Equal(t, StateOpen, cb.currentState())
 }
 
 func TestCircuitBreakerHalfOpenToClose(t *testing.T) {
 	cb := New(testconf)
-	cb.state = cbOpen
-	cb.openAt = time.Now().Add(-testconf.SleepWindow + 2*time.Millisecond)
-	require.Equal(t, cbOpen, cb.currentState())
+	openAt(cb, time.Now().Add(-testconf.SleepWindow+2*time.Millisecond))
+	require.Equal(t, StateOpen, cb.currentState())
 	time.Sleep(2 * time.Millisecond)
-	require.Equal(t, cbHalfOpen, cb.currentState())
-	require.Equal(t, cbOpen, cb.currentState())
-	cb.trace(cbHalfOpen, false, time.Now())
-	require.Equal(t, cbClose, cb.currentState())
+	require.Equal(t, StateHalfOpen, cb.currentState())
+	require.Equal(t, StateOpen, cb.currentState())
+	cb.trace(StateHalfOpen, cb.gen, false, time.Now())
+	require.Equal(t, StateClosed, cb.currentState())
 }
 
 func TestCircuitBreaker(t *testing.T) {
-	testconf.TriggerThreshold = 20
-	testconf.SleepWindow = 100 * time.Millisecond
-	cb := New(testconf)
+	conf := newTestConfig()
+	conf.TriggerThreshold = 20
+	conf.SleepWindow = 100 * time.Millisecond
+	cb := New(conf)
 
 	// OPEN
 	wg := sync.WaitGroup{}
-	for i := 0; i < testconf.TriggerThreshold; i++ {
+	for i := 0; i < conf.TriggerThreshold; i++ {
 		wg.Add(1)
 		go func(i int) {
 			defer wg.Done()
 
 			err := cb.Run(func() error {
-				time.Sleep((testconf.CountWindow / time.Duration(cb.total.size)) * time.Duration(i%4))
+				time.Sleep((conf.CountWindow / time.Duration(cb.total.size)) * time.Duration(i%4))
 				if i%2 == 0 {
 					return errors.New("hello")
 				}
@@ -91,7 +104,7 @@ func TestCircuitBreaker(t *testing.T) {
 	require.True(t, cb.Circuit().IsInterrupted())
 
 	// SLEEP: no request get through
-	for i := 0; i < testconf.TriggerThreshold; i++ {
+	for i := 0; i < conf.TriggerThreshold; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -99,11 +112,11 @@ func TestCircuitBreaker(t *testing.T) {
 		}()
 	}
 	wg.Wait()
-	time.Sleep(testconf.SleepWindow)
+	time.Sleep(conf.SleepWindow)
 
 	// HALF-OPEN: only one request get through
 	var passed int32
-	for i := 1; i < testconf.TriggerThreshold; i++ {
+	for i := 1; i < conf.TriggerThreshold; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -124,7 +137,7 @@ func TestCircuitBreaker(t *testing.T) {
 	}
 
 	for i := 0; i < 3; i++ {
-		time.Sleep(testconf.SleepWindow)
+		time.Sleep(conf.SleepWindow)
 		c := cb.Circuit()
 		if i == 1 {
 			require.True(t, c.IsInterrupted()) // CLOSE -> OPEN
@@ -135,25 +148,509 @@ func TestCircuitBreaker(t *testing.T) {
 		}
 	}
 	// CLOSE
-	for i := 0; i < testconf.TriggerThreshold; i++ {
+	for i := 0; i < conf.TriggerThreshold; i++ {
 		c := cb.Circuit()
 		require.False(t, c.IsInterrupted())
 		c.Trace(false)
 	}
 
-	time.Sleep(testconf.CountWindow)
+	time.Sleep(conf.CountWindow)
 	require.False(t, cb.Circuit().IsInterrupted())
 }
 
 func TestCircuitBreakerDsiabled(t *testing.T) {
-	testconf.TriggerThreshold = 0
-	testconf.HighLatencyValue = 5 * time.Millisecond
-	cb := New(testconf)
-	for i := 0; i < testconf.TriggerThreshold; i++ {
+	conf := newTestConfig()
+	conf.TriggerThreshold = 0
+	conf.HighLatencyValue = 5 * time.Millisecond
+	cb := New(conf)
+	for i := 0; i < conf.TriggerThreshold; i++ {
 		err := cb.Run(func() error {
-			time.Sleep(testconf.HighLatencyValue)
+			time.Sleep(conf.HighLatencyValue)
 			return errors.New("world")
 		})
 		require.NotEqual(t, ErrIsOpen, err)
 	}
 }
+
+func TestCircuitBreakerOnStateChange(t *testing.T) {
+	conf := newTestConfig()
+	conf.TriggerThreshold = 1
+	conf.SleepWindow = time.Millisecond
+	var transitions []string
+	conf.OnStateChange = func(from, to State) {
+		transitions = append(transitions, from.String()+"->"+to.String())
+	}
+	cb := New(conf)
+	require.Equal(t, StateClosed, cb.State())
+
+	c := cb.Circuit()
+	require.False(t, c.IsInterrupted())
+	c.Trace(true)
+	require.True(t, cb.Circuit().IsInterrupted())
+	require.Equal(t, StateOpen, cb.State())
+
+	time.Sleep(2 * conf.SleepWindow)
+	c = cb.Circuit()
+	require.False(t, c.IsInterrupted())
+	require.Equal(t, StateHalfOpen, cb.State())
+	c.Trace(false)
+	require.Equal(t, StateClosed, cb.State())
+
+	require.Equal(t, []string{"closed->open", "open->half-open", "half-open->closed"}, transitions)
+	require.Equal(t, "unknown(9)", State(9).String())
+}
+
+func TestCircuitBreakerHalfOpenProbes(t *testing.T) {
+	conf := newTestConfig()
+	conf.HalfOpenMaxProbes = 2
+	conf.HalfOpenSuccesses = 3
+	cb := New(conf)
+	openAt(cb, time.Now().Add(-conf.SleepWindow))
+
+	// Only 2 probes get passed concurrently.
+	c1, c2 := cb.Circuit(), cb.Circuit()
+	require.False(t, c1.IsInterrupted())
+	require.False(t, c2.IsInterrupted())
+	require.True(t, cb.Circuit().IsInterrupted())
+
+	c1.Trace(false)
+	c2.Trace(false)
+	require.Equal(t, StateHalfOpen, cb.State())
+	c3, c4 := cb.Circuit(), cb.Circuit()
+	require.False(t, c3.IsInterrupted())
+	require.False(t, c4.IsInterrupted())
+	c3.Trace(false)
+	require.Equal(t, StateClosed, cb.State())
+	c4.Trace(true) // Stale probe.
+	require.Equal(t, StateClosed, cb.State())
+
+	// Any failure during probing reopens the circuit.
+	openAt(cb, time.Now().Add(-conf.SleepWindow))
+	c1, c2 = cb.Circuit(), cb.Circuit()
+	c1.Trace(true)
+	require.Equal(t, StateOpen, cb.State())
+	openAt(cb, time.Now().Add(-conf.SleepWindow))
+	c3 = cb.Circuit()
+	require.False(t, c3.IsInterrupted())
+	c2.Trace(false) // Stale probe from the previous HALF-OPEN.
+	require.Equal(t, 1, cb.probes)
+	require.Equal(t, 0, cb.successes)
+	c3.Trace(false)
+	require.Equal(t, 1, cb.successes)
+}
+
+func TestCircuitBreakerTripPolicy(t *testing.T) {
+	conf := newTestConfig()
+	conf.TriggerThreshold = 0
+	conf.TripPolicy = ConsecutiveFailuresTripPolicy(2)
+	cb := New(conf)
+	require.False(t, cb.disabled)
+
+	now := time.Now()
+	cb.trace(StateClosed, 0, true, now)
+	cb.trace(StateClosed, 0, false, now)
+	cb.trace(StateClosed, 0, true, now)
+	require.Equal(t, StateClosed, cb.currentState())
+	cb.trace(StateClosed, 0, true, now)
+	require.Equal(t, StateOpen, cb.currentState())
+}
+
+func TestCircuitBreakerIsFailure(t *testing.T) {
+	require.False(t, DefaultIsFailure(nil))
+	require.False(t, DefaultIsFailure(context.Canceled))
+	require.False(t, DefaultIsFailure(fmt.Errorf("wrapped: %w", context.Canceled)))
+	require.True(t, DefaultIsFailure(context.DeadlineExceeded))
+	require.True(t, DefaultIsFailure(errors.New("x")))
+
+	errNotFound := errors.New("not found")
+	conf := newTestConfig()
+	conf.TripPolicy = ConsecutiveFailuresTripPolicy(1)
+	conf.IsFailure = func(err error) bool {
+		return err != nil && err != errNotFound
+	}
+	cb := New(conf)
+
+	require.Equal(t, errNotFound, cb.Run(func() error { return errNotFound }))
+	cb.Circuit().TraceErr(errNotFound)
+	require.Equal(t, StateClosed, cb.currentState())
+	cb.Circuit().TraceErr(errors.New("fatal"))
+	require.Equal(t, StateOpen, cb.currentState())
+}
+
+func TestCircuitBreakerRunCtx(t *testing.T) {
+	conf := newTestConfig()
+	conf.TripPolicy = ConsecutiveFailuresTripPolicy(2)
+	conf.HighLatencyValue = time.Second
+	conf.Timeout = 5 * time.Millisecond
+	cb := New(conf)
+	ctx := context.TODO()
+
+	var fallbackErrs []error
+	fallback := func(_ context.Context, err error) error {
+		fallbackErrs = append(fallbackErrs, err)
+		return nil
+	}
+	waitCtx := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	require.Nil(t, cb.RunCtx(ctx, func(context.Context) error { return nil }, fallback))
+	require.Equal(t, 0, len(fallbackErrs))
+
+	// Canceled by the caller is not a failure.
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	require.Equal(t, context.Canceled, cb.RunCtx(cctx, waitCtx, nil))
+	require.Equal(t, 0, cb.Counts().ConsecutiveFailures)
+
+	// Timed out.
+	start := time.Now()
+	require.Nil(t, cb.RunCtx(ctx, waitCtx, fallback))
+	require.True(t, time.Since(start) < 100*time.Millisecond)
+	require.Equal(t, 1, cb.Counts().ConsecutiveFailures)
+	err := errors.New("fatal")
+	require.Equal(t, err, cb.RunCtx(ctx, func(context.Context) error { return err }, nil))
+	require.Equal(t, StateOpen, cb.currentState())
+	require.Nil(t, cb.RunCtx(ctx, waitCtx, fallback))
+	require.Equal(t, []error{context.DeadlineExceeded, ErrIsOpen}, fallbackErrs)
+}
+
+func TestCircuitBreakerRunCtxFallback(t *testing.T) {
+	errNotFound := errors.New("not found")
+	conf := newTestConfig()
+	conf.TripPolicy = ConsecutiveFailuresTripPolicy(10)
+	conf.Timeout = 5 * time.Millisecond
+	conf.IsFailure = func(err error) bool {
+		return DefaultIsFailure(err) && err != errNotFound
+	}
+	cb := New(conf)
+
+	called := 0
+	fallback := func(_ context.Context, err error) error {
+		called++
+		return nil
+	}
+	// The cancellation by the caller and the non-failure errors have no fallback.
+	cctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	require.Equal(t, context.Canceled, cb.RunCtx(cctx, func(ctx context.Context) error {
+		return ctx.Err()
+	}, fallback))
+	require.Equal(t, errNotFound, cb.RunCtx(context.TODO(), func(context.Context) error {
+		return errNotFound
+	}, fallback))
+	require.Equal(t, 0, called)
+
+	// The disabled circuitbreaker keeps the Timeout and CoverPanic.
+	conf.TripPolicy = nil
+	conf.TriggerThreshold = 0
+	cb = New(conf)
+	require.True(t, cb.disabled)
+	start := time.Now()
+	require.Nil(t, cb.RunCtx(context.TODO(), func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, fallback))
+	require.True(t, time.Since(start) < 100*time.Millisecond)
+	require.Equal(t, 1, called)
+	require.NotNil(t, cb.Run(func() error { panic("oops") }))
+}
+
+func TestCircuitBreakerRunCtxCallerDeadline(t *testing.T) {
+	conf := newTestConfig()
+	conf.TripPolicy = ConsecutiveFailuresTripPolicy(3)
+	conf.Timeout = time.Second
+	cb := New(conf)
+
+	called := 0
+	fallback := func(_ context.Context, err error) error {
+		called++
+		return err
+	}
+	// The impatient caller never trips the circuitbreaker for others.
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.TODO(), time.Millisecond)
+		err := cb.RunCtx(ctx, func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}, fallback)
+		cancel()
+		require.Equal(t, context.DeadlineExceeded, err)
+	}
+	require.Equal(t, 0, called)
+	require.Equal(t, 0, cb.Counts().ConsecutiveFailures)
+	require.Equal(t, StateClosed, cb.currentState())
+}
+
+func TestCircuitBreakerMetrics(t *testing.T) {
+	conf := DefaultConfig()
+	conf.TripPolicy = PercentileTripPolicy(5, 0.9, 10*time.Millisecond)
+	cb := New(conf)
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		cb.trace(StateClosed, 0, false, now.Add(-20*time.Millisecond))
+	}
+	m := cb.Metrics()
+	require.Equal(t, StateClosed, m.State)
+	require.Equal(t, 5, m.Counts.Requests)
+	require.True(t, m.P50 >= 10*time.Millisecond && m.P50 <= 30*time.Millisecond, "%v", m.P50)
+	require.True(t, m.P50 <= m.P90 && m.P90 <= m.P99)
+	require.Equal(t, m.P99, m.Counts.Percentile(0.99))
+
+	// No failures, evaluated after a bucket interval.
+	require.Equal(t, StateClosed, cb.currentState())
+	require.True(t, m.OpenAt.IsZero())
+	cb.evalAt -= int64(cb.total.interval)
+	require.Equal(t, StateOpen, cb.currentState())
+	require.WithinDuration(t, time.Now(), cb.Metrics().OpenAt, time.Second)
+	require.Equal(t, Metrics{State: StateClosed}, New(Config{}).Metrics())
+}
+
+func TestCircuitBreakerForce(t *testing.T) {
+	conf := DefaultConfig()
+	conf.TripPolicy = ConsecutiveFailuresTripPolicy(1)
+	var transitions []string
+	conf.OnStateChange = func(from, to State) {
+		transitions = append(transitions, from.String()+"->"+to.String())
+	}
+	cb := New(conf)
+
+	cb.ForceOpen()
+	state, forced := cb.Forced()
+	require.True(t, forced)
+	require.Equal(t, StateOpen, state)
+	require.True(t, cb.Circuit().IsInterrupted())
+	require.Equal(t, StateClosed, cb.State())
+	m := cb.Metrics()
+	require.Equal(t, StateClosed, m.State)
+	require.True(t, m.Forced)
+	require.Equal(t, StateOpen, m.ForcedState)
+
+	cb.ForceClosed()
+	for i := 0; i < 3; i++ {
+		c := cb.Circuit()
+		require.False(t, c.IsInterrupted())
+		c.Trace(true)
+	}
+	require.Equal(t, 3, cb.Counts().Errors)
+	state, forced = cb.Forced()
+	require.True(t, forced)
+	require.Equal(t, StateClosed, state)
+
+	cb.Reset()
+	_, forced = cb.Forced()
+	require.False(t, forced)
+	require.Equal(t, 0, cb.Counts().Requests)
+	require.Equal(t, 0, cb.Counts().ConsecutiveFailures)
+	require.Equal(t, time.Duration(0), cb.Metrics().P99)
+	require.False(t, cb.Circuit().IsInterrupted())
+
+	cb.Circuit().Trace(true)
+	require.True(t, cb.Circuit().IsInterrupted())
+	cb.Reset()
+	require.Equal(t, StateClosed, cb.State())
+	require.False(t, cb.Circuit().IsInterrupted())
+	require.Equal(t, []string{"closed->open", "open->closed"}, transitions)
+
+	disabled := New(Config{})
+	disabled.ForceOpen()
+	require.True(t, disabled.Circuit().IsInterrupted())
+	disabled.Reset()
+	require.False(t, disabled.Circuit().IsInterrupted())
+}
+
+func TestCircuitBreakerSleepWindowBackoff(t *testing.T) {
+	conf := DefaultConfig()
+	conf.SleepWindow = 100 * time.Millisecond
+	conf.SleepWindowMultiplier = 2
+	conf.MaxSleepWindow = 500 * time.Millisecond
+	cb := New(conf)
+
+	openAt(cb, time.Now().Add(-time.Hour))
+	for _, expect := range []time.Duration{200, 400, 500, 500} {
+		c := cb.Circuit()
+		require.False(t, c.IsInterrupted())
+		c.Trace(true)
+		require.Equal(t, StateOpen, cb.State())
+		require.Equal(t, int64(expect*time.Millisecond), cb.sleep)
+		cb.openAt -= cb.sleep + 1
+	}
+
+	c := cb.Circuit()
+	require.False(t, c.IsInterrupted())
+	c.Trace(false)
+	require.Equal(t, StateClosed, cb.State())
+	openAt(cb, time.Now())
+	require.Equal(t, int64(conf.SleepWindow), cb.sleep)
+
+	conf.SleepWindowJitter = 0.5
+	cb = New(conf)
+	for i := 0; i < 100; i++ {
+		openAt(cb, time.Now())
+		require.True(t, cb.sleep >= int64(50*time.Millisecond) && cb.sleep < int64(150*time.Millisecond))
+	}
+
+	// The MaxSleepWindow is respected even with the jitter.
+	conf.MaxSleepWindow = conf.SleepWindow
+	cb = New(conf)
+	for i := 0; i < 100; i++ {
+		openAt(cb, time.Now())
+		require.True(t, cb.sleep >= int64(50*time.Millisecond) && cb.sleep <= int64(conf.MaxSleepWindow), "%v", time.Duration(cb.sleep))
+	}
+}
+
+func TestCircuitBreakerRecoveryRamp(t *testing.T) {
+	conf := newTestConfig()
+	conf.RecoveryDuration = 200 * time.Millisecond
+	cb := New(conf)
+	require.False(t, cb.Circuit().IsInterrupted())
+
+	openAt(cb, time.Now().Add(-conf.SleepWindow))
+	c := cb.Circuit()
+	require.False(t, c.IsInterrupted())
+	c.Trace(false)
+	require.Equal(t, StateClosed, cb.State())
+
+	// Most of the requests are rejected right after CLOSE.
+	rejected := 0
+	for i := 0; i < 100; i++ {
+		if cb.Run(func() error { return nil }) == ErrIsOpen {
+			rejected++
+		}
+	}
+	require.True(t, rejected > 50, "rejected: %d", rejected)
+	require.Equal(t, StateClosed, cb.State())
+
+	time.Sleep(conf.RecoveryDuration)
+	for i := 0; i < 100; i++ {
+		require.False(t, cb.Circuit().IsInterrupted())
+	}
+
+	// Reset has no slow-start.
+	openAt(cb, time.Now().Add(-conf.SleepWindow))
+	c = cb.Circuit()
+	require.Equal(t, StateHalfOpen, cb.State())
+	cb.Reset()
+	c.Trace(false)
+	for i := 0; i < 100; i++ {
+		require.False(t, cb.Circuit().IsInterrupted())
+	}
+}
+
+func TestCircuitBreakerRecoveryRampAfterFailures(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	conf := DefaultConfig()
+	conf.Clock = fake
+	conf.RecoveryDuration = 10 * time.Second
+	var transitions []string
+	conf.OnStateChange = func(from, to State) {
+		transitions = append(transitions, from.String()+"->"+to.String())
+	}
+	cb := New(conf)
+
+	errFail := errors.New("fail")
+	for i := 0; i < conf.TriggerThreshold; i++ {
+		require.Equal(t, errFail, cb.Run(func() error { return errFail }))
+	}
+	require.Equal(t, StateOpen, cb.currentState())
+	fake.Add(conf.SleepWindow + time.Nanosecond)
+	require.Nil(t, cb.Run(func() error { return nil }))
+	require.Equal(t, StateClosed, cb.State())
+
+	// The failures which opened it do not trip it again during the slow-start.
+	fake.Add(conf.RecoveryDuration / 2)
+	admitted := 0
+	for i := 0; i < 100; i++ {
+		if cb.Run(func() error { return nil }) == nil {
+			admitted++
+		}
+	}
+	require.True(t, admitted > 20, "admitted: %d", admitted)
+	require.Equal(t, StateClosed, cb.State())
+	require.Equal(t, []string{"closed->open", "open->half-open", "half-open->closed"}, transitions)
+}
+
+func TestRampFraction(t *testing.T) {
+	for _, r := range []Ramp{RampLinear, RampExponential} {
+		require.Equal(t, 1.0, r.fraction(1))
+		prev := r.fraction(0)
+		require.True(t, prev < 0.01)
+		for x := 0.1; x <= 1; x += 0.1 {
+			f := r.fraction(x)
+			require.True(t, f > prev)
+			prev = f
+		}
+	}
+	require.Equal(t, 0.5, RampLinear.fraction(0.5))
+	require.InDelta(t, 0.5, RampExponential.fraction(0.9), 1e-9)
+}
+
+func TestConfigValidate(t *testing.T) {
+	require.Nil(t, DefaultConfig().Validate())
+	require.Nil(t, Config{}.Validate())
+
+	for field, mutate := range map[string]func(*Config){
+		"TriggerThreshold":      func(c *Config) { c.TriggerThreshold = -1 },
+		"ErrorRate":             func(c *Config) { c.ErrorRate = 1.5 },
+		"HighLatencyRate":       func(c *Config) { c.HighLatencyRate = 0 },
+		"CountWindow":           func(c *Config) { c.CountWindow = 0 },
+		"SleepWindow":           func(c *Config) { c.SleepWindow = -1 },
+		"SleepWindowMultiplier": func(c *Config) { c.SleepWindowMultiplier = -2 },
+		"MaxSleepWindow":        func(c *Config) { c.MaxSleepWindow = time.Millisecond },
+		"SleepWindowJitter":     func(c *Config) { c.SleepWindowJitter = 1 },
+		"HighLatencyValue":      func(c *Config) { c.HighLatencyValue = 0 },
+		"Timeout":               func(c *Config) { c.Timeout = -1 },
+		"HalfOpenMaxProbes":     func(c *Config) { c.HalfOpenMaxProbes = -1 },
+		"HalfOpenSuccesses":     func(c *Config) { c.HalfOpenSuccesses = -1 },
+		"RecoveryDuration":      func(c *Config) { c.RecoveryDuration = -1 },
+		"RecoveryRamp":          func(c *Config) { c.RecoveryRamp = 3 },
+	} {
+		conf := DefaultConfig()
+		mutate(&conf)
+		cb, err := NewE(conf)
+		require.Nil(t, cb)
+		var cerr *ConfigError
+		require.True(t, errors.As(err, &cerr), field)
+		require.Equal(t, field, cerr.Field)
+	}
+
+	// The rates are not used by the custom TripPolicy.
+	conf := DefaultConfig()
+	conf.ErrorRate = 0
+	conf.TripPolicy = ConsecutiveFailuresTripPolicy(3)
+	cb, err := NewE(conf)
+	require.Nil(t, err)
+	require.NotNil(t, cb)
+}
+
+func TestCircuitBreakerClock(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	conf := DefaultConfig()
+	conf.Clock = fake
+	conf.TripPolicy = ConsecutiveFailuresTripPolicy(2)
+	cb := New(conf)
+
+	// The latency is measured by the clock.
+	for i := 0; i < 2; i++ {
+		require.Nil(t, cb.Run(func() error {
+			fake.Add(conf.HighLatencyValue)
+			return nil
+		}))
+	}
+	require.Equal(t, 2, cb.Counts().Timeouts)
+	require.Equal(t, ErrIsOpen, cb.Run(func() error { return nil }))
+	require.Equal(t, fake.Now(), cb.Metrics().OpenAt)
+
+	fake.Add(conf.SleepWindow)
+	require.Equal(t, ErrIsOpen, cb.Run(func() error { return nil }))
+	fake.Add(time.Nanosecond)
+	require.Nil(t, cb.Run(func() error { return nil }))
+	require.Equal(t, StateClosed, cb.State())
+
+	// The stats are dropped after the CountWindow.
+	require.Equal(t, 3, cb.Counts().Requests)
+	fake.Add(conf.CountWindow)
+	require.Equal(t, 0, cb.Counts().Requests)
+}
@@ -0,0 +1,102 @@
+package circuitbreaker
+
+import (
+	"sort"
+	"sync"
+)
+
+// BreakerInfo is the information of a named CircuitBreaker.
+type BreakerInfo struct {
+	Name string
+	Metrics
+}
+
+// Registry manages the named CircuitBreakers, such as one CircuitBreaker
+// for each downstream endpoint, it is goroutine safe.
+type Registry struct {
+	l        sync.RWMutex
+	deflt    Config
+	confs    map[string]Config
+	breakers map[string]*CircuitBreaker
+}
+
+// NewRegistry creates a new Registry, the deflt is used for the names
+// which have no config, a *ConfigError is returned if it is invalid.
+func NewRegistry(deflt Config) (*Registry, error) {
+	if err := deflt.Validate(); err != nil {
+		return nil, err
+	}
+	return &Registry{
+		deflt:    deflt,
+		confs:    make(map[string]Config),
+		breakers: make(map[string]*CircuitBreaker),
+	}, nil
+}
+
+// Get returns the CircuitBreaker for the name, creates it if not exists,
+// the configs are validated by NewRegistry and SetConfig.
+// Since the CircuitBreaker may be replaced by SetConfig, DO NOT keep it for long.
+func (r *Registry) Get(name string) *CircuitBreaker {
+	r.l.RLock()
+	cb, ok := r.breakers[name]
+	r.l.RUnlock()
+	if ok {
+		return cb
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+	if cb, ok = r.breakers[name]; ok { // Double check.
+		return cb
+	}
+	conf, ok := r.confs[name]
+	if !ok {
+		conf = r.deflt
+	}
+	cb = New(conf)
+	r.breakers[name] = cb
+	return cb
+}
+
+// SetConfig sets the config for the name, the existing CircuitBreaker
+// will be replaced with a new one, so all stats are dropped.
+// A *ConfigError is returned if the conf is invalid, nothing is changed then.
+func (r *Registry) SetConfig(name string, conf Config) error {
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.confs[name] = conf
+	if _, ok := r.breakers[name]; ok {
+		r.breakers[name] = New(conf)
+	}
+	return nil
+}
+
+// Remove removes the CircuitBreaker and the config for the name.
+func (r *Registry) Remove(name string) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	delete(r.confs, name)
+	delete(r.breakers, name)
+}
+
+// List returns the information of all CircuitBreakers sorted by name.
+func (r *Registry) List() []BreakerInfo {
+	r.l.RLock()
+	infos := make([]BreakerInfo, 0, len(r.breakers))
+	breakers := make([]*CircuitBreaker, 0, len(r.breakers))
+	for name, cb := range r.breakers {
+		infos = append(infos, BreakerInfo{Name: name})
+		breakers = append(breakers, cb)
+	}
+	r.l.RUnlock()
+
+	// Collect the stats outside the lock of Registry.
+	for i, cb := range breakers {
+		infos[i].Metrics = cb.Metrics()
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
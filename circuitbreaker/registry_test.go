@@ -0,0 +1,75 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	conf := DefaultConfig()
+	r, err := NewRegistry(conf)
+	require.Nil(t, err)
+
+	wg := sync.WaitGroup{}
+	breakers := make([]*CircuitBreaker, 10)
+	for i := range breakers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			breakers[i] = r.Get("a")
+		}(i)
+	}
+	wg.Wait()
+	for _, cb := range breakers {
+		require.True(t, cb == breakers[0])
+	}
+	require.Equal(t, conf.TriggerThreshold, r.Get("b").conf.TriggerThreshold)
+
+	conf1 := conf
+	conf1.TripPolicy = ConsecutiveFailuresTripPolicy(1)
+	require.Nil(t, r.SetConfig("c", conf1))
+	require.Equal(t, errors.New("x"), r.Get("c").Run(func() error { return errors.New("x") }))
+	require.Nil(t, r.Get("a").Run(func() error { return nil }))
+
+	infos := r.List()
+	require.Equal(t, 3, len(infos))
+	require.Equal(t, "a", infos[0].Name)
+	require.Equal(t, StateClosed, infos[0].State)
+	require.Equal(t, 1, infos[0].Counts.Requests)
+	require.Equal(t, 0, infos[0].Counts.Errors)
+	require.Equal(t, "b", infos[1].Name)
+	require.Equal(t, "c", infos[2].Name)
+	require.Equal(t, StateClosed, infos[2].State)
+	require.Equal(t, 1, infos[2].Counts.Requests)
+	require.Equal(t, 1, infos[2].Counts.Errors)
+	require.Equal(t, 1, infos[2].Counts.ConsecutiveFailures)
+	require.Equal(t, ErrIsOpen, r.Get("c").Run(func() error { return nil }))
+
+	// Reload.
+	cb := r.Get("c")
+	conf1.HighLatencyValue = time.Hour
+	require.Nil(t, r.SetConfig("c", conf1))
+	require.True(t, cb != r.Get("c"))
+	require.Equal(t, StateClosed, r.Get("c").State())
+	require.Equal(t, time.Hour, r.Get("c").conf.HighLatencyValue)
+
+	r.Remove("c")
+	r.Remove("b")
+	require.Equal(t, 1, len(r.List()))
+	require.Equal(t, conf.HighLatencyValue, r.Get("c").conf.HighLatencyValue)
+
+	// The invalid configs are rejected.
+	invalid := conf
+	invalid.ErrorRate = 2
+	_, err = NewRegistry(invalid)
+	var cerr *ConfigError
+	require.True(t, errors.As(err, &cerr))
+	require.Equal(t, "ErrorRate", cerr.Field)
+	cb = r.Get("a")
+	require.True(t, errors.As(r.SetConfig("a", invalid), &cerr))
+	require.True(t, cb == r.Get("a"))
+}
@@ -1,62 +1,187 @@
 package circuitbreaker
 
 import (
+	"math/rand"
+	"runtime"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	cacheLineSize = 64
+	maxShards     = 32
+	// minInterval is the min interval of buckets, so a tiny window
+	// will not end up with a zero interval.
+	minInterval = time.Millisecond
+)
+
+// rollingCounter is a lock-free counter with a sliding window, it is sharded
+// to reduce the contention between CPUs.
+//
+// Each bucket has width slots(e.g. the bins of a histogram), each slot is a
+// uint64 composed of epoch<<32|count, the epoch is the (truncated) number of
+// intervals since base, so the slot can be reset and increased with a single CAS.
+//
+// The sums of the buckets before the current epoch are cached, so Count only
+// loads the current bucket of each shard most of the time, the increments
+// which land in the past buckets late are invisible until the next epoch.
 type rollingCounter struct {
-	values   []int
+	shards   [][]uint64
+	cache    []uint64 // (epoch+1)<<32|sum of each slot, accessed atomically.
+	mask     uint64
 	size     int
-	lastidx  int
-	lasttime time.Time
+	width    int
+	base     time.Time
 	interval time.Duration
 }
 
 func newRollingCounter(window time.Duration, now time.Time) *rollingCounter {
+	return newShardedRollingCounter(window, now, defaultShards(maxShards), 1)
+}
+
+// defaultShards returns the number of shards by GOMAXPROCS, at most max.
+func defaultShards(max int) int {
+	nshard := 1
+	for nshard < runtime.GOMAXPROCS(0) && nshard < max {
+		nshard <<= 1
+	}
+	return nshard
+}
+
+// newShardedRollingCounter creates a rollingCounter with nshard shards and width
+// slots per bucket, the nshard must be a power of 2.
+func newShardedRollingCounter(window time.Duration, now time.Time, nshard, width int) *rollingCounter {
 	interval := time.Second
 	size := int(window / interval)
 	if size < 10 {
 		size = 10
 		interval = window / time.Duration(size)
+		if interval < minInterval {
+			interval = minInterval
+			size = int(window / interval)
+			if size < 1 {
+				size = 1
+			}
+		}
+	}
+
+	shards := make([][]uint64, nshard)
+	for i := range shards {
+		// Pad the tail to avoid false sharing between shards.
+		shards[i] = make([]uint64, size*width, size*width+cacheLineSize/8)
 	}
 	return &rollingCounter{
-		values:   make([]int, size, size),
+		shards:   shards,
+		cache:    make([]uint64, width),
+		mask:     uint64(nshard - 1),
 		size:     size,
-		lastidx:  0,
-		lasttime: now,
+		width:    width,
+		base:     now,
 		interval: interval,
 	}
 }
 
+func (rc *rollingCounter) epoch(now time.Time) uint32 {
+	elapsed := now.Sub(rc.base)
+	if elapsed < 0 {
+		return 0
+	}
+	return uint32(elapsed / rc.interval)
+}
+
+// shard picks a random shard, so the concurrent callers are spread
+// even if they share the same timestamp(e.g. a coarse or fake clock),
+// the top-level functions of math/rand are cheap and lock-free since Go 1.20.
+func (rc *rollingCounter) shard() []uint64 {
+	if rc.mask == 0 {
+		return rc.shards[0]
+	}
+	return rc.shards[uint64(rand.Uint32())&rc.mask]
+}
+
 func (rc *rollingCounter) Count(now time.Time) int {
-	rc.advance(now)
+	var counts [1]int
+	rc.counts(now, counts[:])
+	return counts[0]
+}
 
-	count := 0
-	for _, v := range rc.values {
-		count += v
+// counts stores the count of each slot in the window into counts.
+func (rc *rollingCounter) counts(now time.Time, counts []int) {
+	epoch := rc.epoch(now)
+	rc.past(epoch, counts)
+	cur := int(epoch%uint32(rc.size)) * rc.width
+	for _, values := range rc.shards {
+		for i := range counts {
+			if v := atomic.LoadUint64(&values[cur+i]); uint32(v>>32) == epoch {
+				counts[i] += int(uint32(v))
+			}
+		}
+	}
+}
+
+// past stores the sum of each slot in the window before the epoch into sums,
+// it is computed at most once per epoch in general.
+func (rc *rollingCounter) past(epoch uint32, sums []int) {
+	cached := true
+	for i := range sums {
+		c := atomic.LoadUint64(&rc.cache[i])
+		if uint32(c>>32) != epoch+1 {
+			cached = false
+			break
+		}
+		sums[i] = int(uint32(c))
+	}
+	if cached {
+		return
+	}
+
+	for i := range sums {
+		sums[i] = 0
+	}
+	size := uint32(rc.size)
+	for _, values := range rc.shards {
+		for i := range values {
+			v := atomic.LoadUint64(&values[i])
+			if e := uint32(v >> 32); e != epoch && epoch-e < size {
+				sums[i%rc.width] += int(uint32(v))
+			}
+		}
+	}
+	for i, sum := range sums {
+		atomic.StoreUint64(&rc.cache[i], uint64(epoch+1)<<32|uint64(uint32(sum)))
 	}
-	return count
 }
 
 func (rc *rollingCounter) Incr(now time.Time) {
-	rc.advance(now)
-	rc.values[rc.lastidx]++ // that's..
+	rc.incr(now, 0)
 }
 
-func (rc *rollingCounter) advance(now time.Time) {
-	elapsed := int(now.Sub(rc.lasttime) / rc.interval)
-	if elapsed < 1 {
-		return
+// incr increases the slot of the current bucket.
+func (rc *rollingCounter) incr(now time.Time, slot int) {
+	epoch := rc.epoch(now)
+	values := rc.shard()
+	p := &values[int(epoch%uint32(rc.size))*rc.width+slot]
+	for {
+		old := atomic.LoadUint64(p)
+		v := uint64(epoch)<<32 | 1
+		if oldepoch := uint32(old >> 32); oldepoch == epoch {
+			v = old + 1
+		} else if int32(oldepoch-epoch) > 0 {
+			return // The bucket has been reused by the later ones, that's too late..
+		}
+		if atomic.CompareAndSwapUint64(p, old, v) {
+			return
+		}
 	}
+}
 
-	rc.lasttime = now
-	if elapsed > rc.size {
-		elapsed = rc.size
+func (rc *rollingCounter) Reset() {
+	for _, values := range rc.shards {
+		for i := range values {
+			atomic.StoreUint64(&values[i], 0)
+		}
 	}
-	oldidx := rc.lastidx + 1
-	lastidx := rc.lastidx + elapsed
-	rc.lastidx = lastidx % rc.size
-	for ; oldidx <= lastidx; oldidx++ {
-		rc.values[oldidx%rc.size] = 0
+	for i := range rc.cache {
+		atomic.StoreUint64(&rc.cache[i], 0)
 	}
 }
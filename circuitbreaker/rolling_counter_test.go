@@ -1,6 +1,7 @@
 package circuitbreaker
 
 import (
+	"sync"
 	"testing"
 	"time"
 
@@ -48,3 +49,59 @@ func TestRollingCounter(t *testing.T) {
 	now = now.Add(2 * duration)
 	require.Equal(t, 0, rc.Count(now))
 }
+
+func TestRollingCounterTinyWindow(t *testing.T) {
+	now := time.Now()
+	for window, size := range map[time.Duration]int{
+		0:                     1,
+		5 * time.Millisecond:  5,
+		20 * time.Millisecond: 10,
+	} {
+		rc := newRollingCounter(window, now)
+		require.Equal(t, size, rc.size)
+		require.True(t, rc.interval >= minInterval)
+		rc.Incr(now)
+		require.Equal(t, 1, rc.Count(now))
+	}
+}
+
+func TestRollingCounterConcurrent(t *testing.T) {
+	now := time.Now()
+	rc := newRollingCounter(time.Second, now)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				rc.Incr(time.Now())
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 16*1000, rc.Count(time.Now()))
+}
+
+func TestRollingCounterSameInstant(t *testing.T) {
+	now := time.Now()
+	rc := newShardedRollingCounter(time.Second, now, 8, 1)
+	for i := 0; i < 1000; i++ {
+		rc.Incr(now)
+	}
+	require.Equal(t, 1000, rc.Count(now))
+
+	// The callers sharing the same timestamp are still spread in shards.
+	used := 0
+	for _, values := range rc.shards {
+		if values[rc.epoch(now)%uint32(rc.size)] != 0 {
+			used++
+		}
+	}
+	require.True(t, used > 1, "%d", used)
+
+	now = now.Add(rc.interval)
+	rc.Incr(now)
+	require.Equal(t, 1001, rc.Count(now))
+	require.Equal(t, 1001, rc.Count(now)) // Cached.
+}
@@ -0,0 +1,91 @@
+package circuitbreaker
+
+import (
+	"math"
+	"time"
+)
+
+// latencyBounds are the upper bounds of the latency bins, which grows
+// exponentially from 100us to about 1min, the last bin counts the rest.
+var latencyBounds = func() []time.Duration {
+	bounds := []time.Duration{}
+	for bound := 100 * time.Microsecond; bound < time.Minute; bound = bound * 3 / 2 {
+		bounds = append(bounds, bound)
+	}
+	return append(bounds, time.Minute)
+}()
+
+// maxHistogramShards bounds the memory of the rollingHistogram, which is
+// len(latencyBounds)+1 times larger than the rollingCounter, e.g. about 23KB
+// for a 20s window with 4 shards.
+const maxHistogramShards = 4
+
+// rollingHistogram is a latency histogram with a sliding window, the bins
+// are the slots of a sharded rollingCounter, so each bucket of the window
+// keeps a histogram, and most requests which land in a few bins are still
+// spread in a few shards.
+type rollingHistogram struct {
+	counter *rollingCounter
+}
+
+func newRollingHistogram(window time.Duration, now time.Time) *rollingHistogram {
+	return &rollingHistogram{
+		counter: newShardedRollingCounter(window, now, defaultShards(maxHistogramShards), len(latencyBounds)+1),
+	}
+}
+
+func (rh *rollingHistogram) Observe(now time.Time, latency time.Duration) {
+	idx := len(latencyBounds)
+	// Binary search is not worth it for such small number of bins.
+	for i, bound := range latencyBounds {
+		if latency <= bound {
+			idx = i
+			break
+		}
+	}
+	rh.counter.incr(now, idx)
+}
+
+func (rh *rollingHistogram) Reset() {
+	rh.counter.Reset()
+}
+
+func (rh *rollingHistogram) Distribution(now time.Time) []int {
+	counts := make([]int, rh.counter.width)
+	rh.counter.counts(now, counts)
+	return counts
+}
+
+// percentile returns the p-th(in (0, 1]) percentile of the distribution,
+// the value is linearly interpolated in the bin, 0 returned if no stats.
+func percentile(counts []int, p float64) time.Duration {
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p * float64(total)))
+	if rank < 1 {
+		rank = 1
+	}
+	cum := 0
+	for i, c := range counts {
+		if cum+c < rank {
+			cum += c
+			continue
+		}
+		if i == len(latencyBounds) {
+			break // Overflow, we know nothing more..
+		}
+		lower := time.Duration(0)
+		if i > 0 {
+			lower = latencyBounds[i-1]
+		}
+		upper := latencyBounds[i]
+		return lower + time.Duration(float64(upper-lower)*float64(rank-cum)/float64(c))
+	}
+	return latencyBounds[len(latencyBounds)-1]
+}
@@ -0,0 +1,59 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollingHistogram(t *testing.T) {
+	now := time.Now()
+	rh := newRollingHistogram(time.Second, now)
+	require.Equal(t, time.Duration(0), percentile(rh.Distribution(now), 0.99))
+
+	for i := 1; i <= 100; i++ {
+		rh.Observe(now, time.Duration(i)*time.Millisecond)
+	}
+	dist := rh.Distribution(now)
+	for _, c := range []struct {
+		p      float64
+		expect time.Duration
+	}{
+		{0.5, 50 * time.Millisecond},
+		{0.9, 90 * time.Millisecond},
+		{0.99, 99 * time.Millisecond},
+	} {
+		actual := percentile(dist, c.p)
+		// The error is bounded by the width of the bin.
+		require.InDelta(t, float64(c.expect), float64(actual), float64(c.expect)/2, "p%v: %v", c.p*100, actual)
+	}
+
+	rh.Observe(now, time.Hour)
+	require.Equal(t, time.Minute, percentile(rh.Distribution(now), 1))
+
+	// Expired.
+	require.Equal(t, time.Duration(0), percentile(rh.Distribution(now.Add(time.Second)), 0.5))
+}
+
+func TestRollingHistogramBuckets(t *testing.T) {
+	now := time.Now()
+	rh := newRollingHistogram(time.Second, now)
+	rh.Observe(now, 100*time.Microsecond)
+	now = now.Add(rh.counter.interval)
+	rh.Observe(now, 100*time.Microsecond)
+	rh.Observe(now, time.Hour)
+
+	expect := make([]int, len(latencyBounds)+1)
+	expect[0], expect[len(latencyBounds)] = 2, 1
+	require.Equal(t, expect, rh.Distribution(now))
+	require.Equal(t, expect, rh.Distribution(now)) // Cached.
+
+	rh.Reset()
+	require.Equal(t, make([]int, len(expect)), rh.Distribution(now))
+}
+
+func TestRollingHistogramShards(t *testing.T) {
+	rh := newRollingHistogram(20*time.Second, time.Now())
+	require.True(t, len(rh.counter.shards) <= maxHistogramShards)
+}
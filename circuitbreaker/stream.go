@@ -0,0 +1,137 @@
+package circuitbreaker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/damnever/goctl/clock"
+)
+
+// Snapshot is the JSON view of a named CircuitBreaker, the durations are in milliseconds.
+type Snapshot struct {
+	Name                string     `json:"name"`
+	State               string     `json:"state"`
+	ForcedState         string     `json:"forced_state,omitempty"`
+	OpenAt              *time.Time `json:"open_at,omitempty"`
+	Requests            int        `json:"requests"`
+	Errors              int        `json:"errors"`
+	Timeouts            int        `json:"timeouts"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	ErrorRate           float64    `json:"error_rate"`
+	HighLatencyRate     float64    `json:"high_latency_rate"`
+	LatencyP50          float64    `json:"latency_p50_ms"`
+	LatencyP90          float64    `json:"latency_p90_ms"`
+	LatencyP99          float64    `json:"latency_p99_ms"`
+}
+
+func newSnapshot(info BreakerInfo) Snapshot {
+	millis := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	s := Snapshot{
+		Name:                info.Name,
+		State:               info.State.String(),
+		Requests:            info.Counts.Requests,
+		Errors:              info.Counts.Errors,
+		Timeouts:            info.Counts.Timeouts,
+		ConsecutiveFailures: info.Counts.ConsecutiveFailures,
+		LatencyP50:          millis(info.P50),
+		LatencyP90:          millis(info.P90),
+		LatencyP99:          millis(info.P99),
+	}
+	if info.Forced {
+		s.ForcedState = info.ForcedState.String()
+	}
+	if !info.OpenAt.IsZero() {
+		openAt := info.OpenAt
+		s.OpenAt = &openAt
+	}
+	if total := float64(info.Counts.Requests); total > 0 {
+		s.ErrorRate = float64(info.Counts.Errors) / total
+		s.HighLatencyRate = float64(info.Counts.Timeouts) / total
+	}
+	return s
+}
+
+type streamHandler struct {
+	registry *Registry
+	interval time.Duration
+	clock    clock.Clock
+}
+
+// NewStreamHandler creates a http.Handler which serves the Snapshots of
+// the CircuitBreakers in registry as a JSON array. The Snapshots are streamed
+// every interval(1s if it is not greater than 0) by Server-Sent Events if the
+// request accepts "text/event-stream", otherwise they are served once for scraping.
+// The breakers can be selected by the "name" query parameters, e.g. "?name=a&name=b",
+// all breakers are served if no name given.
+// The stream is driven by the Clock of the registry's default Config.
+func NewStreamHandler(registry *Registry, interval time.Duration) http.Handler {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	clk := registry.deflt.Clock
+	if clk == nil {
+		clk = clock.New()
+	}
+	return &streamHandler{
+		registry: registry,
+		interval: interval,
+		clock:    clk,
+	}
+}
+
+func (h *streamHandler) snapshots(names []string) []Snapshot {
+	selected := make(map[string]bool, len(names))
+	for _, name := range names {
+		selected[name] = true
+	}
+	infos := h.registry.List()
+	snapshots := make([]Snapshot, 0, len(infos))
+	for _, info := range infos {
+		if len(selected) == 0 || selected[info.Name] {
+			snapshots = append(snapshots, newSnapshot(info))
+		}
+	}
+	return snapshots
+}
+
+func (h *streamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	names := r.URL.Query()["name"]
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(h.snapshots(names))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ticker := h.clock.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		data, err := json.Marshal(h.snapshots(names))
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return // The client has gone.
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package circuitbreaker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/damnever/goctl/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func newStreamTestRegistry() *Registry {
+	return newStreamTestRegistryWithClock(nil)
+}
+
+func newStreamTestRegistryWithClock(clk clock.Clock) *Registry {
+	conf := DefaultConfig()
+	conf.Clock = clk
+	conf.TripPolicy = ConsecutiveFailuresTripPolicy(1)
+	registry, err := NewRegistry(conf)
+	if err != nil {
+		panic(err)
+	}
+	registry.Get("a").Circuit().Trace(false)
+	c := registry.Get("b").Circuit()
+	c.Trace(true)
+	registry.Get("b").Circuit() // Trip.
+	return registry
+}
+
+func TestStreamHandlerOnce(t *testing.T) {
+	handler := NewStreamHandler(newStreamTestRegistry(), 0)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var snapshots []Snapshot
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &snapshots))
+	require.Equal(t, 2, len(snapshots))
+
+	a, b := snapshots[0], snapshots[1]
+	require.Equal(t, "a", a.Name)
+	require.Equal(t, "closed", a.State)
+	require.Nil(t, a.OpenAt)
+	require.Equal(t, 1, a.Requests)
+	require.Equal(t, 0.0, a.ErrorRate)
+	require.Equal(t, "b", b.Name)
+	require.Equal(t, "open", b.State)
+	require.NotNil(t, b.OpenAt)
+	require.Equal(t, 1.0, b.ErrorRate)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/?name=b&name=c", nil))
+	snapshots = nil
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &snapshots))
+	require.Equal(t, 1, len(snapshots))
+	require.Equal(t, "b", snapshots[0].Name)
+}
+
+func TestStreamHandlerSSE(t *testing.T) {
+	registry := newStreamTestRegistry()
+	server := httptest.NewServer(NewStreamHandler(registry, 10*time.Millisecond))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequest("GET", server.URL+"?name=a", nil)
+	require.Nil(t, err)
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	scanner := bufio.NewScanner(resp.Body)
+	next := func() Snapshot {
+		var line string
+		for line == "" {
+			require.True(t, scanner.Scan())
+			line = scanner.Text()
+		}
+		require.True(t, strings.HasPrefix(line, "data: "), line)
+		var snapshots []Snapshot
+		require.Nil(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &snapshots))
+		require.Equal(t, 1, len(snapshots))
+		return snapshots[0]
+	}
+	require.Equal(t, 1, next().Requests)
+	registry.Get("a").Circuit().Trace(false)
+	for i := 0; next().Requests != 2; i++ {
+		require.True(t, i < 10)
+	}
+}
+
+func TestStreamHandlerSSEClock(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	registry := newStreamTestRegistryWithClock(clk)
+	server := httptest.NewServer(NewStreamHandler(registry, time.Hour))
+	defer server.Close()
+
+	req, err := http.NewRequest("GET", server.URL+"?name=a", nil)
+	require.Nil(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for i := 0; i < 3; i++ {
+		require.True(t, scanner.Scan())
+		require.True(t, strings.HasPrefix(scanner.Text(), "data: "), scanner.Text())
+		require.True(t, scanner.Scan()) // The blank line.
+		clk.BlockUntil(1)
+		clk.Add(time.Hour)
+	}
+}
@@ -0,0 +1,74 @@
+package circuitbreaker
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// TransportOptions configures the RoundTripper created by NewTransport.
+type TransportOptions struct {
+	// KeyFunc returns the name of CircuitBreaker for the request,
+	// nil means the host(with port) of the request URL.
+	KeyFunc func(req *http.Request) string
+	// IsFailure classifies the result of the request, nil means DefaultIsHTTPFailure.
+	IsFailure func(resp *http.Response, err error) bool
+}
+
+// DefaultIsHTTPFailure treats the errors(classified by DefaultIsFailure), such as
+// connection errors and timeouts, and the 5xx responses as failure.
+func DefaultIsHTTPFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return DefaultIsFailure(err)
+	}
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
+type transport struct {
+	base     http.RoundTripper
+	registry *Registry
+	opts     TransportOptions
+}
+
+// NewTransport creates a http.RoundTripper which protects each destination
+// by the CircuitBreaker in registry, a error wraps ErrIsOpen(use errors.Is to check it)
+// is returned if the CircuitBreaker is OPEN.
+// The latency is measured until the response headers are received, the errors
+// caused by the context of the request are not counted as failure.
+// If base is nil, http.DefaultTransport is used, if registry is nil,
+// NewRegistry(DefaultConfig()) is used.
+func NewTransport(base http.RoundTripper, registry *Registry, opts TransportOptions) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if registry == nil {
+		registry, _ = NewRegistry(DefaultConfig()) // The DefaultConfig is always valid.
+	}
+	if opts.KeyFunc == nil {
+		opts.KeyFunc = func(req *http.Request) string { return req.URL.Host }
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = DefaultIsHTTPFailure
+	}
+	return &transport{
+		base:     base,
+		registry: registry,
+		opts:     opts,
+	}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := t.opts.KeyFunc(req)
+	c := t.registry.Get(key).Circuit()
+	if c.IsInterrupted() {
+		// The RoundTripper must always close the body.
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("%w: %s", ErrIsOpen, key)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	// The request canceled or timed out by the caller is not the fault of the destination.
+	c.Trace(t.opts.IsFailure(resp, err) && (err == nil || req.Context().Err() == nil))
+	return resp, err
+}
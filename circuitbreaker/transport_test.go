@@ -0,0 +1,123 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/notfound":
+			w.WriteHeader(http.StatusNotFound)
+		case "/fail":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	conf := DefaultConfig()
+	conf.TripPolicy = ConsecutiveFailuresTripPolicy(2)
+	registry, err := NewRegistry(conf)
+	require.Nil(t, err)
+	client := &http.Client{Transport: NewTransport(nil, registry, TransportOptions{})}
+	get := func(path string) (int, error) {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		code, err := get("/notfound")
+		require.Nil(t, err)
+		require.Equal(t, http.StatusNotFound, code)
+	}
+	for i := 0; i < 2; i++ {
+		code, err := get("/fail")
+		require.Nil(t, err)
+		require.Equal(t, http.StatusServiceUnavailable, code)
+	}
+	_, err = get("/")
+	require.True(t, errors.Is(err, ErrIsOpen), "%v", err)
+
+	u, _ := url.Parse(server.URL)
+	infos := registry.List()
+	require.Equal(t, 1, len(infos))
+	require.Equal(t, u.Host, infos[0].Name)
+	require.Equal(t, StateOpen, infos[0].State)
+	require.Equal(t, 5, infos[0].Counts.Requests)
+	require.Equal(t, 2, infos[0].Counts.Errors)
+}
+
+func TestTransportKeyFunc(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	conf := DefaultConfig()
+	conf.TripPolicy = ConsecutiveFailuresTripPolicy(1)
+	registry, err := NewRegistry(conf)
+	require.Nil(t, err)
+	client := &http.Client{Transport: NewTransport(nil, registry, TransportOptions{
+		KeyFunc: func(req *http.Request) string { return req.URL.Path },
+		IsFailure: func(resp *http.Response, err error) bool {
+			return err != nil || resp.StatusCode == http.StatusInternalServerError
+		},
+	})}
+
+	for _, path := range []string{"/a", "/b"} {
+		resp, err := client.Get(server.URL + path)
+		require.Nil(t, err)
+		resp.Body.Close()
+	}
+	for _, path := range []string{"/a", "/b"} {
+		_, err := client.Get(server.URL + path)
+		require.True(t, errors.Is(err, ErrIsOpen), "%v", err)
+	}
+	require.Equal(t, 2, len(registry.List()))
+
+	require.False(t, DefaultIsHTTPFailure(&http.Response{StatusCode: http.StatusBadRequest}, nil))
+	require.True(t, DefaultIsHTTPFailure(nil, errors.New("connection refused")))
+}
+
+func TestTransportCallerTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	conf := DefaultConfig()
+	conf.TripPolicy = ConsecutiveFailuresTripPolicy(1)
+	registry, err := NewRegistry(conf)
+	require.Nil(t, err)
+	client := &http.Client{Transport: NewTransport(nil, registry, TransportOptions{
+		IsFailure: func(resp *http.Response, err error) bool { return err != nil },
+	})}
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.TODO(), time.Millisecond)
+		req, err := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+		require.Nil(t, err)
+		_, err = client.Do(req)
+		cancel()
+		require.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	}
+	infos := registry.List()
+	require.Equal(t, 1, len(infos))
+	require.Equal(t, StateClosed, infos[0].State)
+	require.Equal(t, 0, infos[0].Counts.Errors)
+}
@@ -0,0 +1,104 @@
+package circuitbreaker
+
+import "time"
+
+// Counts is the stats of requests for the TripPolicy.
+type Counts struct {
+	// Requests is the number of requests in the CountWindow.
+	Requests int
+	// Errors is the number of failed requests in the CountWindow.
+	Errors int
+	// Timeouts is the number of high latency requests in the CountWindow.
+	Timeouts int
+	// ConsecutiveFailures is the number of consecutive failed or high latency requests.
+	ConsecutiveFailures int
+
+	latencies *rollingHistogram
+	at        time.Time
+}
+
+// Percentile returns the p-th(in (0, 1]) percentile of the latency in the CountWindow,
+// it is computed on demand, 0 returned if no stats.
+func (c Counts) Percentile(p float64) time.Duration {
+	if c.latencies == nil {
+		return 0
+	}
+	return percentile(c.latencies.Distribution(c.at), p)
+}
+
+// TripPolicy decides whether the circuitbreaker should be OPEN from CLOSE.
+//
+// To keep the hot path cheap, it is evaluated on the request only if there are
+// new failures since the last evaluation, otherwise it is evaluated at most once
+// per millisecond if there are failures in the CountWindow, or at most once per
+// bucket interval(1s or CountWindow/10).
+type TripPolicy interface {
+	// ShouldTrip returns true if the circuitbreaker should be OPEN.
+	ShouldTrip(counts Counts) bool
+}
+
+// TripPolicyFunc is an adapter to allow the use of ordinary functions as TripPolicy.
+type TripPolicyFunc func(counts Counts) bool
+
+// ShouldTrip calls f(counts).
+func (f TripPolicyFunc) ShouldTrip(counts Counts) bool {
+	return f(counts)
+}
+
+// RateTripPolicy creates a TripPolicy which trips if the number of requests reachs the
+// threshold(must be greater than 0) and the error rate or the rate of high latency exceeds
+// the errorRate/highLatencyRate, it is the default policy.
+func RateTripPolicy(threshold int, errorRate, highLatencyRate float64) TripPolicy {
+	return TripPolicyFunc(func(counts Counts) bool {
+		if counts.Requests <= 0 || counts.Requests < threshold {
+			return false
+		}
+		total := float64(counts.Requests)
+		return float64(counts.Errors)/total >= errorRate ||
+			float64(counts.Timeouts)/total >= highLatencyRate
+	})
+}
+
+// ConsecutiveFailuresTripPolicy creates a TripPolicy which trips if the number of
+// consecutive failures reachs n, it reacts quickly for low-traffic dependencies.
+func ConsecutiveFailuresTripPolicy(n int) TripPolicy {
+	return TripPolicyFunc(func(counts Counts) bool {
+		return counts.ConsecutiveFailures >= n
+	})
+}
+
+// PercentileTripPolicy creates a TripPolicy which trips if the number of requests
+// reachs the threshold and the p-th(in (0, 1]) percentile of latency exceeds the
+// max, e.g. PercentileTripPolicy(20, 0.99, 800*time.Millisecond).
+func PercentileTripPolicy(threshold int, p float64, max time.Duration) TripPolicy {
+	return TripPolicyFunc(func(counts Counts) bool {
+		if counts.Requests <= 0 || counts.Requests < threshold {
+			return false
+		}
+		return counts.Percentile(p) > max
+	})
+}
+
+// AnyTripPolicy creates a TripPolicy which trips if any of the policies trips.
+func AnyTripPolicy(policies ...TripPolicy) TripPolicy {
+	return TripPolicyFunc(func(counts Counts) bool {
+		for _, p := range policies {
+			if p.ShouldTrip(counts) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// AllTripPolicy creates a TripPolicy which trips if all of the policies trip.
+func AllTripPolicy(policies ...TripPolicy) TripPolicy {
+	return TripPolicyFunc(func(counts Counts) bool {
+		for _, p := range policies {
+			if !p.ShouldTrip(counts) {
+				return false
+			}
+		}
+		return len(policies) > 0
+	})
+}
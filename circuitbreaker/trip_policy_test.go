@@ -0,0 +1,58 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateTripPolicy(t *testing.T) {
+	p := RateTripPolicy(10, 0.5, 0.3)
+	require.False(t, p.ShouldTrip(Counts{}))
+	require.False(t, p.ShouldTrip(Counts{Requests: 9, Errors: 9}))
+	require.False(t, p.ShouldTrip(Counts{Requests: 10, Errors: 4, Timeouts: 2}))
+	require.True(t, p.ShouldTrip(Counts{Requests: 10, Errors: 5}))
+	require.True(t, p.ShouldTrip(Counts{Requests: 10, Timeouts: 3}))
+}
+
+func TestConsecutiveFailuresTripPolicy(t *testing.T) {
+	p := ConsecutiveFailuresTripPolicy(3)
+	require.False(t, p.ShouldTrip(Counts{Requests: 100, Errors: 100, ConsecutiveFailures: 2}))
+	require.True(t, p.ShouldTrip(Counts{Requests: 3, ConsecutiveFailures: 3}))
+}
+
+func TestCombinedTripPolicy(t *testing.T) {
+	rate := RateTripPolicy(10, 0.5, 0.5)
+	consecutive := ConsecutiveFailuresTripPolicy(3)
+	counts := []Counts{
+		{Requests: 3, Errors: 3, ConsecutiveFailures: 3},
+		{Requests: 10, Errors: 5, ConsecutiveFailures: 1},
+		{Requests: 10, Errors: 5, ConsecutiveFailures: 3},
+		{Requests: 10, Errors: 1, ConsecutiveFailures: 1},
+	}
+
+	anyp := AnyTripPolicy(rate, consecutive)
+	for i, expect := range []bool{true, true, true, false} {
+		require.Equal(t, expect, anyp.ShouldTrip(counts[i]), "%d", i)
+	}
+	allp := AllTripPolicy(rate, consecutive)
+	for i, expect := range []bool{false, false, true, false} {
+		require.Equal(t, expect, allp.ShouldTrip(counts[i]), "%d", i)
+	}
+	require.False(t, AnyTripPolicy().ShouldTrip(counts[0]))
+	require.False(t, AllTripPolicy().ShouldTrip(counts[0]))
+}
+
+func TestPercentileTripPolicy(t *testing.T) {
+	now := time.Now()
+	rh := newRollingHistogram(time.Second, now)
+	p := PercentileTripPolicy(10, 0.99, 800*time.Millisecond)
+	for i := 0; i < 9; i++ {
+		rh.Observe(now, time.Second)
+	}
+	require.False(t, p.ShouldTrip(Counts{Requests: 9, latencies: rh, at: now}))
+	rh.Observe(now, time.Millisecond)
+	require.True(t, p.ShouldTrip(Counts{Requests: 10, latencies: rh, at: now}))
+	require.False(t, p.ShouldTrip(Counts{Requests: 10}))
+}
@@ -0,0 +1,74 @@
+// Package clock provides an abstraction of time, so the time-dependent
+// code can be tested deterministically with the Fake.
+package clock
+
+import "time"
+
+// Clock tells the time and creates the timers and tickers.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// Since returns the time elapsed since t.
+	Since(t time.Time) time.Duration
+	// NewTimer creates a new Timer which fires after d.
+	NewTimer(d time.Duration) Timer
+	// NewTicker creates a new Ticker which ticks every d, d must be greater than 0.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is the abstraction of time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is delivered.
+	C() <-chan time.Time
+	// Stop prevents the Timer from firing, see time.Timer.Stop.
+	Stop() bool
+	// Reset changes the Timer to expire after d, see time.Timer.Reset.
+	Reset(d time.Duration) bool
+}
+
+// Ticker is the abstraction of time.Ticker.
+type Ticker interface {
+	// C returns the channel on which the ticks are delivered.
+	C() <-chan time.Time
+	// Stop turns off the Ticker.
+	Stop()
+}
+
+type realClock struct{}
+
+// New returns the Clock backed by the time package.
+func New() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
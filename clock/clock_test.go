@@ -0,0 +1,23 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRealClock(t *testing.T) {
+	c := New()
+	start := c.Now()
+
+	timer := c.NewTimer(time.Millisecond)
+	<-timer.C()
+	require.False(t, timer.Stop())
+	require.True(t, c.Since(start) >= time.Millisecond)
+
+	ticker := c.NewTicker(time.Millisecond)
+	<-ticker.C()
+	<-ticker.C()
+	ticker.Stop()
+}
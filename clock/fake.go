@@ -0,0 +1,169 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a Clock which only moves forward by Add or Set, the timers and
+// tickers fire in the goroutine which moves the Fake.
+// It is goroutine safe.
+type Fake struct {
+	l       sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// NewFake creates a new Fake with the initial time now.
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.l)
+	return f
+}
+
+// Now returns the current time of the Fake.
+func (f *Fake) Now() time.Time {
+	f.l.Lock()
+	defer f.l.Unlock()
+	return f.now
+}
+
+// Since returns the time elapsed since t.
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// NewTimer creates a new Timer which fires once the Fake moves d forward,
+// it fires immediately if d is not greater than 0.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	w := &fakeWaiter{f: f, c: make(chan time.Time, 1)}
+	w.Reset(d)
+	return w
+}
+
+// NewTicker creates a new Ticker which ticks every d while the Fake moves forward.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	w := &fakeWaiter{f: f, c: make(chan time.Time, 1), period: d}
+	w.Reset(d)
+	return fakeTicker{w}
+}
+
+// Add moves the Fake d forward and fires the timers and tickers in order.
+func (f *Fake) Add(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the Fake forward to t and fires the timers and tickers in order,
+// it does nothing if t is before the current time.
+func (f *Fake) Set(t time.Time) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	for len(f.waiters) > 0 {
+		sort.Slice(f.waiters, func(i, j int) bool {
+			return f.waiters[i].when.Before(f.waiters[j].when)
+		})
+		w := f.waiters[0]
+		if w.when.After(t) {
+			break
+		}
+		if w.when.After(f.now) {
+			f.now = w.when
+		}
+		w.fire()
+	}
+	if t.After(f.now) {
+		f.now = t
+	}
+}
+
+// Waiters returns the number of active timers and tickers.
+func (f *Fake) Waiters() int {
+	f.l.Lock()
+	defer f.l.Unlock()
+	return len(f.waiters)
+}
+
+// BlockUntil blocks until the number of active timers and tickers
+// reaches n, so the caller knows the code under test is waiting.
+func (f *Fake) BlockUntil(n int) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+// add must be called with the lock held.
+func (f *Fake) add(w *fakeWaiter) {
+	f.waiters = append(f.waiters, w)
+	f.cond.Broadcast()
+}
+
+// remove must be called with the lock held.
+func (f *Fake) remove(w *fakeWaiter) bool {
+	for i, x := range f.waiters {
+		if x == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fakeWaiter implements both Timer and Ticker.
+type fakeWaiter struct {
+	f      *Fake
+	c      chan time.Time
+	when   time.Time
+	period time.Duration // 0 means Timer.
+}
+
+func (w *fakeWaiter) C() <-chan time.Time {
+	return w.c
+}
+
+// fire must be called with the lock held.
+func (w *fakeWaiter) fire() {
+	select {
+	case w.c <- w.when:
+	default: // Drop it like the time.Ticker does for slow receivers.
+	}
+	if w.period > 0 {
+		w.when = w.when.Add(w.period)
+	} else {
+		w.f.remove(w)
+	}
+}
+
+func (w *fakeWaiter) Stop() bool {
+	w.f.l.Lock()
+	defer w.f.l.Unlock()
+	return w.f.remove(w)
+}
+
+func (w *fakeWaiter) Reset(d time.Duration) bool {
+	w.f.l.Lock()
+	defer w.f.l.Unlock()
+	active := w.f.remove(w)
+	w.when = w.f.now.Add(d)
+	if d <= 0 && w.period == 0 {
+		w.fire()
+	} else {
+		w.f.add(w)
+	}
+	return active
+}
+
+type fakeTicker struct {
+	*fakeWaiter
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeWaiter.Stop()
+}
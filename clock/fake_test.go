@@ -0,0 +1,76 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeTimer(t *testing.T) {
+	start := time.Unix(0, 0)
+	f := NewFake(start)
+	require.Equal(t, start, f.Now())
+
+	timer := f.NewTimer(time.Second)
+	require.Equal(t, 1, f.Waiters())
+	f.Add(999 * time.Millisecond)
+	select {
+	case <-timer.C():
+		t.Fatal("fired too early")
+	default:
+	}
+	f.Add(time.Millisecond)
+	require.Equal(t, start.Add(time.Second), <-timer.C())
+	require.Equal(t, time.Second, f.Since(start))
+	require.Equal(t, 0, f.Waiters())
+	require.False(t, timer.Stop())
+
+	require.False(t, timer.Reset(time.Second))
+	require.True(t, timer.Stop())
+	f.Add(time.Hour)
+	select {
+	case <-timer.C():
+		t.Fatal("stopped timer fired")
+	default:
+	}
+
+	timer = f.NewTimer(0)
+	<-timer.C()
+}
+
+func TestFakeTicker(t *testing.T) {
+	start := time.Unix(0, 0)
+	f := NewFake(start)
+	ticker := f.NewTicker(time.Second)
+
+	for i := 1; i <= 3; i++ {
+		f.Add(time.Second)
+		require.Equal(t, start.Add(time.Duration(i)*time.Second), <-ticker.C())
+	}
+	// The ticks are dropped for the slow receiver.
+	f.Add(10 * time.Second)
+	require.Equal(t, start.Add(4*time.Second), <-ticker.C())
+	ticker.Stop()
+	require.Equal(t, 0, f.Waiters())
+
+	t1, t2 := f.NewTimer(2*time.Second), f.NewTimer(time.Second)
+	f.Add(3 * time.Second)
+	require.Equal(t, start.Add(15*time.Second), <-t1.C())
+	require.Equal(t, start.Add(14*time.Second), <-t2.C())
+
+	f.Set(start)
+	require.Equal(t, start.Add(16*time.Second), f.Now())
+}
+
+func TestFakeBlockUntil(t *testing.T) {
+	f := NewFake(time.Now())
+	donec := make(chan struct{})
+	go func() {
+		defer close(donec)
+		<-f.NewTimer(time.Minute).C()
+	}()
+	f.BlockUntil(1)
+	f.Add(time.Minute)
+	<-donec
+}
@@ -11,6 +11,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/damnever/goctl/clock"
 )
 
 var (
@@ -68,6 +70,8 @@ type Options struct {
 	// ResetOnBorrow will reset the resource before borrow,
 	// the resource must implement the ResetableResource interface.
 	ResetOnBorrow bool
+	// Clock is used to check the IdleTimeout, nil means clock.New().
+	Clock clock.Clock
 }
 
 func (opts Options) validate() error {
@@ -88,13 +92,13 @@ type resourceWrapper struct {
 func newResourceWrapper(resource Resource, opts Options) resourceWrapper {
 	r := resourceWrapper{resource: resource}
 	if opts.IdleTimeout > 0 {
-		r.idleAt = time.Now()
+		r.idleAt = opts.Clock.Now()
 	}
 	return r
 }
 
 func (r resourceWrapper) checkOnBorrow(opts Options) bool {
-	return r.reset(opts.ResetOnBorrow) && r.test(opts.IdleTimeout, opts.TestOnBorrow, opts.TestWhileIdle)
+	return r.reset(opts.ResetOnBorrow) && r.test(opts.Clock, opts.IdleTimeout, opts.TestOnBorrow, opts.TestWhileIdle)
 }
 
 func (r resourceWrapper) reset(resetOnBorrow bool) bool {
@@ -108,12 +112,12 @@ func (r resourceWrapper) reset(resetOnBorrow bool) bool {
 	return false
 }
 
-func (r resourceWrapper) test(d time.Duration, testOnBorrow, testWhileIdle bool) bool {
+func (r resourceWrapper) test(clk clock.Clock, d time.Duration, testOnBorrow, testWhileIdle bool) bool {
 	if d <= 0 { // No IdleTimeout
 		if testOnBorrow {
 			goto TEST
 		}
-	} else if r.idleAt.Add(d).After(time.Now()) {
+	} else if r.idleAt.Add(d).After(clk.Now()) {
 		if testOnBorrow {
 			goto TEST
 		}
@@ -160,6 +164,9 @@ func NewWithCtx(ctx context.Context, opts Options) (*Pool, error) {
 	if err := opts.validate(); err != nil {
 		return nil, err
 	}
+	if opts.Clock == nil {
+		opts.Clock = clock.New()
+	}
 
 	ctx, cancel := context.WithCancel(ctx)
 	return &Pool{
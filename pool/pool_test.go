@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/damnever/goctl/clock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -223,6 +224,35 @@ func TestResourceTesting(t *testing.T) {
 	}
 }
 
+func TestIdleTimeoutWithClock(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	opts := Options{
+		ResourceFactory: newFakeTestableResource,
+		Capacity:        1,
+		IdleTimeout:     time.Minute,
+		TestWhileIdle:   true,
+		Clock:           fake,
+	}
+	pool, err := New(opts)
+	require.Nil(t, err)
+	defer pool.Close()
+
+	r, err := pool.GetNoWait()
+	require.Nil(t, err)
+	require.Nil(t, pool.Put(r))
+	fake.Add(opts.IdleTimeout - time.Second)
+	r, err = pool.GetNoWait()
+	require.Nil(t, err)
+	require.Equal(t, 0, r.(*fakeTestableResource).testcalls)
+
+	require.Nil(t, pool.Put(r))
+	fake.Add(opts.IdleTimeout)
+	r, err = pool.GetNoWait()
+	require.Nil(t, err)
+	require.Equal(t, 1, r.(*fakeTestableResource).testcalls)
+	require.Nil(t, pool.Put(r))
+}
+
 func TestErrors(t *testing.T) {
 	opts := Options{
 		ResourceFactory: newFakeTestableResource,
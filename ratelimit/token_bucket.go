@@ -5,10 +5,12 @@ import (
 	"sync"
 	"time"
 
+	"github.com/damnever/goctl/clock"
 	"github.com/damnever/goctl/queue"
 )
 
 type tokenBucketRateLimiter struct {
+	clock clock.Clock
 	reqc  chan *tokenReq
 	semc  chan struct{}
 	stopc chan struct{}
@@ -31,7 +33,14 @@ type tokenBucketRateLimiter struct {
 //     defer l.Close()
 //     err := l.Take(ctx, 1<<20) // take 1MB
 func NewTokenBucketRateLimiter(limit int) RateLimiter {
+	return NewTokenBucketRateLimiterWithClock(limit, clock.New())
+}
+
+// NewTokenBucketRateLimiterWithClock is like NewTokenBucketRateLimiter,
+// but the tokens are refilled by the ticker of clk.
+func NewTokenBucketRateLimiterWithClock(limit int, clk clock.Clock) RateLimiter {
 	l := &tokenBucketRateLimiter{
+		clock: clk,
 		reqc:  make(chan *tokenReq),
 		stopc: make(chan struct{}),
 		donec: make(chan struct{}),
@@ -72,7 +81,7 @@ func (l *tokenBucketRateLimiter) scheduling(limit int) {
 			pending.Pop()
 		}
 	}
-	ticker := time.NewTicker(interval)
+	ticker := l.clock.NewTicker(interval)
 	defer ticker.Stop()
 
 	reqc := l.reqc
@@ -86,7 +95,7 @@ func (l *tokenBucketRateLimiter) scheduling(limit int) {
 				}
 			}
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 			if x := bucket + token; x < limit {
 				bucket = x
 			} else {
@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/damnever/goctl/clock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -50,6 +51,25 @@ func TestBPSLikeRateLimit(t *testing.T) {
 	}
 }
 
+func TestRateLimitWithClock(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	l := NewTokenBucketRateLimiterWithClock(10, fake) // 1 token per 100ms.
+	defer l.Close()
+	fake.BlockUntil(1)
+
+	require.Nil(t, l.Take(context.TODO(), 1))
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+		require.Equal(t, context.DeadlineExceeded, l.Take(ctx, 1))
+		cancel()
+
+		donec := make(chan error, 1)
+		go func() { donec <- l.Take(context.TODO(), 1) }()
+		fake.Add(100 * time.Millisecond)
+		require.Nil(t, <-donec)
+	}
+}
+
 func TestNoStarving(t *testing.T) {
 	MB200 := 200 * (1 << 20)
 	l := NewTokenBucketRateLimiter(MB200)
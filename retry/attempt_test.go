@@ -0,0 +1,89 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/damnever/goctl/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunAttempt(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	var (
+		attempts []Attempt
+		retries  []time.Duration
+	)
+	r := NewWithOptions(Options{
+		NewBackoff:     func() Backoff { return SliceBackoff(ConstantBackoffs(2, time.Second)) },
+		Clock:          fake,
+		AttemptTimeout: time.Hour,
+		OnRetry: func(attempt Attempt, err error, backoff time.Duration) {
+			require.Equal(t, len(attempts), attempt.Number)
+			require.Equal(t, attempt.Number, err.(numberError).n)
+			if attempt.Number == 1 {
+				require.Nil(t, attempt.PrevErr)
+			} else {
+				require.Equal(t, numberError{n: attempt.Number - 1}, attempt.PrevErr)
+			}
+			retries = append(retries, backoff)
+		},
+	})
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- r.RunAttempt(context.TODO(), func(attempt Attempt) (State, error) {
+			_, ok := attempt.Ctx.Deadline()
+			require.True(t, ok)
+			attempts = append(attempts, attempt)
+			return Continue, numberError{n: attempt.Number}
+		})
+	}()
+	for i := 0; i < 2; i++ {
+		fake.BlockUntil(1)
+		fake.Add(time.Second)
+	}
+	require.Equal(t, numberError{n: 3}, <-errc)
+	require.Equal(t, []time.Duration{time.Second, time.Second}, retries)
+	require.Equal(t, 3, len(attempts))
+	for i, attempt := range attempts {
+		require.Equal(t, i+1, attempt.Number)
+		require.Equal(t, time.Duration(i)*time.Second, attempt.Elapsed)
+		if i == 0 {
+			require.Nil(t, attempt.PrevErr)
+		} else {
+			require.Equal(t, numberError{n: i}, attempt.PrevErr)
+		}
+	}
+}
+
+func TestRunAttemptTimeout(t *testing.T) {
+	r := NewWithOptions(Options{
+		NewBackoff:     func() Backoff { return SliceBackoff(ZeroBackoffs(1)) },
+		AttemptTimeout: time.Millisecond,
+	})
+	cnt := 0
+	err := r.RunAttempt(context.TODO(), func(attempt Attempt) (State, error) {
+		cnt++
+		<-attempt.Ctx.Done()
+		return Continue, attempt.Ctx.Err()
+	})
+	require.Equal(t, 2, cnt)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// The zero Retrier works too.
+	require.Equal(t, ErrNeedRetry, Retrier{}.RunAttempt(context.TODO(), func(attempt Attempt) (State, error) {
+		require.Equal(t, 1, attempt.Number)
+		return Continue, ErrNeedRetry
+	}))
+}
+
+type numberError struct {
+	n int
+}
+
+func (e numberError) Error() string {
+	return "attempt failed"
+}
@@ -0,0 +1,169 @@
+package retry
+
+import (
+	"time"
+
+	"github.com/damnever/goctl/clock"
+)
+
+// Backoff generates the wait time before each retrying lazily, so the
+// unbounded or time-capped retrying can be expressed.
+// The Backoff is stateful, it is not goroutine safe.
+type Backoff interface {
+	// Next returns the wait time before the next retrying,
+	// false means no more retrying.
+	Next() (time.Duration, bool)
+	// Reset resets the Backoff to the initial state.
+	Reset()
+}
+
+// limitedBackoff is implemented by the Backoffs which limit the backoff
+// itself, so the delay hinted by After can be checked against the limits.
+type limitedBackoff interface {
+	allows(backoff time.Duration) bool
+}
+
+// allows returns true if the b allows to wait for backoff before the next
+// retrying, the Backoffs without such limits allow any backoff.
+func allows(b Backoff, backoff time.Duration) bool {
+	if lb, ok := b.(limitedBackoff); ok {
+		return lb.allows(backoff)
+	}
+	return true
+}
+
+type sliceBackoff struct {
+	backoffs []time.Duration
+	i        int
+}
+
+// SliceBackoff adapts a list of backoffs to the Backoff.
+func SliceBackoff(backoffs []time.Duration) Backoff {
+	return &sliceBackoff{backoffs: backoffs}
+}
+
+func (b *sliceBackoff) Next() (time.Duration, bool) {
+	if b.i >= len(b.backoffs) {
+		return 0, false
+	}
+	b.i++
+	return b.backoffs[b.i-1], true
+}
+
+func (b *sliceBackoff) Reset() {
+	b.i = 0
+}
+
+// Durations resets the b and takes at most n backoffs from it.
+func Durations(b Backoff, n int) []time.Duration {
+	b.Reset()
+	backoffs := make([]time.Duration, 0, n+1)
+	for i := 0; i < n; i++ {
+		backoff, ok := b.Next()
+		if !ok {
+			break
+		}
+		backoffs = append(backoffs, backoff)
+	}
+	return backoffs
+}
+
+type constantBackoff time.Duration
+
+// Constant creates an unbounded Backoff with the constant value.
+func Constant(backoff time.Duration) Backoff {
+	return constantBackoff(backoff)
+}
+
+func (b constantBackoff) Next() (time.Duration, bool) {
+	return time.Duration(b), true
+}
+
+func (constantBackoff) Reset() {}
+
+type exponentialBackoff struct {
+	backoff time.Duration
+	max     time.Duration
+	i       int
+}
+
+// Exponential creates an unbounded Backoff with values are calculated by
+// min(max, backoff*2^[0 1 2 ..]), the max is ignored if it is not greater than 0.
+func Exponential(backoff, max time.Duration) Backoff {
+	return &exponentialBackoff{backoff: backoff, max: max}
+}
+
+func (b *exponentialBackoff) Next() (time.Duration, bool) {
+	d := cappedExponential(b.backoff, b.max, b.i)
+	if b.i < 64 { // It can not grow anymore, avoid the overflow of i.
+		b.i++
+	}
+	return d, true
+}
+
+func (b *exponentialBackoff) Reset() {
+	b.i = 0
+}
+
+type maxAttemptsBackoff struct {
+	b        Backoff
+	attempts int
+	n        int
+}
+
+// WithMaxAttempts limits the b, so the try is called at most n times(including
+// the first call which is not counted in retrying).
+func WithMaxAttempts(b Backoff, n int) Backoff {
+	return &maxAttemptsBackoff{b: b, attempts: 1, n: n}
+}
+
+func (b *maxAttemptsBackoff) Next() (time.Duration, bool) {
+	if b.attempts >= b.n {
+		return 0, false
+	}
+	b.attempts++
+	return b.b.Next()
+}
+
+func (b *maxAttemptsBackoff) allows(backoff time.Duration) bool {
+	return allows(b.b, backoff)
+}
+
+func (b *maxAttemptsBackoff) Reset() {
+	b.attempts = 1
+	b.b.Reset()
+}
+
+type maxElapsedBackoff struct {
+	b       Backoff
+	clock   clock.Clock
+	max     time.Duration
+	startAt time.Time
+}
+
+// WithMaxElapsed limits the b, so no more retrying if the time elapsed since
+// creating or Reset plus the next backoff exceeds max.
+// The clk is used to tell the time, nil means clock.New().
+func WithMaxElapsed(b Backoff, max time.Duration, clk clock.Clock) Backoff {
+	if clk == nil {
+		clk = clock.New()
+	}
+	return &maxElapsedBackoff{b: b, clock: clk, max: max, startAt: clk.Now()}
+}
+
+func (b *maxElapsedBackoff) Next() (time.Duration, bool) {
+	backoff, ok := b.b.Next()
+	if !ok || !b.allows(backoff) {
+		return 0, false
+	}
+	return backoff, true
+}
+
+func (b *maxElapsedBackoff) allows(backoff time.Duration) bool {
+	return b.clock.Since(b.startAt)+backoff <= b.max && allows(b.b, backoff)
+}
+
+func (b *maxElapsedBackoff) Reset() {
+	b.startAt = b.clock.Now()
+	b.b.Reset()
+}
@@ -0,0 +1,92 @@
+package retry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/damnever/goctl/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSliceBackoff(t *testing.T) {
+	backoffs := ExponentialBackoffs(3, time.Millisecond)
+	b := SliceBackoff(backoffs)
+	require.Equal(t, backoffs, Durations(b, 10))
+	_, ok := b.Next()
+	require.False(t, ok)
+	require.Equal(t, backoffs[:2], Durations(b, 2))
+	require.Equal(t, 0, len(Durations(SliceBackoff(nil), 2)))
+}
+
+func TestUnboundedBackoffs(t *testing.T) {
+	ms := time.Millisecond
+	require.Equal(t, ConstantBackoffs(100, ms), Durations(Constant(ms), 100))
+
+	b := Exponential(ms, time.Second)
+	backoffs := Durations(b, 100)
+	require.Equal(t, ExponentialBackoffs(10, ms), backoffs[:10])
+	require.Equal(t, time.Second, backoffs[99])
+	require.Equal(t, ms, Durations(b, 1)[0])
+}
+
+func TestWithMaxAttempts(t *testing.T) {
+	b := WithMaxAttempts(Constant(0), 3)
+	require.Equal(t, 2, len(Durations(b, 10)))
+	require.Equal(t, 2, len(Durations(b, 10)))
+	require.Equal(t, 0, len(Durations(WithMaxAttempts(Constant(0), 1), 10)))
+}
+
+func TestWithMaxElapsed(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	b := WithMaxElapsed(Constant(time.Second), 3*time.Second, fake)
+	for i := 0; i < 3; i++ {
+		backoff, ok := b.Next()
+		require.True(t, ok)
+		fake.Add(backoff)
+	}
+	_, ok := b.Next()
+	require.False(t, ok)
+
+	b.Reset()
+	_, ok = b.Next()
+	require.True(t, ok)
+	fake.Add(2500 * time.Millisecond)
+	_, ok = b.Next()
+	require.False(t, ok)
+}
+
+func TestRetrierWithOptions(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	r := NewWithOptions(Options{
+		NewBackoff: func() Backoff {
+			return WithMaxElapsed(Exponential(time.Second, 4*time.Second), time.Minute, fake)
+		},
+		Clock: fake,
+	})
+
+	for run := 0; run < 2; run++ { // The Backoff is created for each Run.
+		cnt := 0
+		errc := make(chan error, 1)
+		go func() {
+			errc <- r.Run(context.TODO(), func() (State, error) {
+				cnt++
+				return Continue, ErrNeedRetry
+			})
+		}()
+		// 1+2+4*14=59s, 4s more exceeds the limit.
+		for _, backoff := range Durations(Exponential(time.Second, 4*time.Second), 16) {
+			fake.BlockUntil(1)
+			fake.Add(backoff)
+		}
+		require.Equal(t, ErrNeedRetry, <-errc)
+		require.Equal(t, 17, cnt)
+	}
+
+	cnt := 0
+	require.Equal(t, ErrNeedRetry, NewWithOptions(Options{}).Run(context.TODO(), func() (State, error) {
+		cnt++
+		return Continue, ErrNeedRetry
+	}))
+	require.Equal(t, 1, cnt)
+}
@@ -0,0 +1,141 @@
+package retry
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/damnever/goctl/clock"
+)
+
+// ErrBudgetExhausted indicates the retrying is rejected by the Budget,
+// use errors.Is to check the error returned by Run.
+var ErrBudgetExhausted = errors.New("retry budget exhausted")
+
+// BudgetExhaustedError wraps the last error returned by the try,
+// it is returned by Run if the Budget is exhausted.
+type BudgetExhaustedError struct {
+	Err error
+}
+
+func (e *BudgetExhaustedError) Error() string {
+	return ErrBudgetExhausted.Error() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the last error.
+func (e *BudgetExhaustedError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the target is ErrBudgetExhausted.
+func (e *BudgetExhaustedError) Is(target error) bool {
+	return target == ErrBudgetExhausted
+}
+
+// BudgetOptions configures the Budget.
+type BudgetOptions struct {
+	// Ratio is the max ratio of retries to requests(Runs) in the Window, e.g. 0.1.
+	Ratio float64
+	// MinRetriesPerSecond is the floor of retries, so the low-traffic callers can
+	// still retry, it is counted in the Window too.
+	MinRetriesPerSecond int
+	// Window is the time window to count the requests and retries, 0 means 10s.
+	Window time.Duration
+	// Clock is used to tell the time, nil means clock.New().
+	Clock clock.Clock
+}
+
+type budgetBucket struct {
+	epoch    int64
+	requests int
+	retries  int
+}
+
+// Budget limits the retries, so the retrying will not amplify the load of
+// the degraded system, it is goroutine safe, share it between Retriers.
+type Budget struct {
+	l          sync.Mutex
+	clock      clock.Clock
+	ratio      float64
+	minRetries int
+	base       time.Time
+	interval   time.Duration
+	buckets    []budgetBucket
+}
+
+// NewBudget creates a new Budget.
+func NewBudget(opts BudgetOptions) *Budget {
+	if opts.Window <= 0 {
+		opts.Window = 10 * time.Second
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.New()
+	}
+	size := 10
+	interval := opts.Window / time.Duration(size)
+	if interval <= 0 {
+		interval = 1
+	}
+	return &Budget{
+		clock:      opts.Clock,
+		ratio:      opts.Ratio,
+		minRetries: int(float64(opts.MinRetriesPerSecond) * opts.Window.Seconds()),
+		base:       opts.Clock.Now(),
+		interval:   interval,
+		buckets:    make([]budgetBucket, size),
+	}
+}
+
+// bucket must be called with the lock held.
+func (b *Budget) bucket(epoch int64) *budgetBucket {
+	bucket := &b.buckets[epoch%int64(len(b.buckets))]
+	if bucket.epoch != epoch {
+		*bucket = budgetBucket{epoch: epoch}
+	}
+	return bucket
+}
+
+// available must be called with the lock held.
+func (b *Budget) available(epoch int64) int {
+	requests, retries := 0, 0
+	for _, bucket := range b.buckets {
+		if epoch-bucket.epoch < int64(len(b.buckets)) {
+			requests += bucket.requests
+			retries += bucket.retries
+		}
+	}
+	if available := int(b.ratio*float64(requests)) + b.minRetries - retries; available > 0 {
+		return available
+	}
+	return 0
+}
+
+func (b *Budget) epoch() int64 {
+	return int64(b.clock.Since(b.base) / b.interval)
+}
+
+// request records a request(Run).
+func (b *Budget) request() {
+	b.l.Lock()
+	defer b.l.Unlock()
+	b.bucket(b.epoch()).requests++
+}
+
+// withdraw records a retry and returns true if it is allowed.
+func (b *Budget) withdraw() bool {
+	b.l.Lock()
+	defer b.l.Unlock()
+	epoch := b.epoch()
+	if b.available(epoch) <= 0 {
+		return false
+	}
+	b.bucket(epoch).retries++
+	return true
+}
+
+// Available returns the number of retries allowed currently.
+func (b *Budget) Available() int {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return b.available(b.epoch())
+}
@@ -0,0 +1,72 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/damnever/goctl/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBudget(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	b := NewBudget(BudgetOptions{Ratio: 0.1, MinRetriesPerSecond: 1, Window: 10 * time.Second, Clock: fake})
+	require.Equal(t, 10, b.Available())
+	for i := 0; i < 10; i++ {
+		require.True(t, b.withdraw())
+	}
+	require.False(t, b.withdraw())
+
+	for i := 0; i < 20; i++ {
+		b.request()
+	}
+	require.Equal(t, 2, b.Available())
+	fake.Add(5 * time.Second)
+	require.True(t, b.withdraw())
+	require.Equal(t, 1, b.Available())
+
+	// The stats are dropped after the Window.
+	fake.Add(10 * time.Second)
+	require.Equal(t, 10, b.Available())
+}
+
+func TestRetrierWithBudget(t *testing.T) {
+	b := NewBudget(BudgetOptions{Ratio: 0.5})
+	errFail := errors.New("fail")
+	tries := 0
+	l := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	// The Retrier is shared between goroutines.
+	r := NewWithOptions(Options{
+		NewBackoff: func() Backoff { return WithMaxAttempts(Constant(0), 3) },
+		Budget:     b,
+	})
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = r.Run(context.TODO(), func() (State, error) {
+				l.Lock()
+				tries++
+				l.Unlock()
+				return Continue, errFail
+			})
+		}()
+	}
+	wg.Wait()
+	// At most 10*0.5 retries.
+	require.True(t, tries >= 10 && tries <= 15, "tries: %d", tries)
+
+	b = NewBudget(BudgetOptions{})
+	err := NewWithOptions(Options{NewBackoff: func() Backoff { return Constant(0) }, Budget: b}).Run(context.TODO(), func() (State, error) {
+		return Continue, errFail
+	})
+	require.True(t, errors.Is(err, ErrBudgetExhausted))
+	require.True(t, errors.Is(err, errFail))
+	var berr *BudgetExhaustedError
+	require.True(t, errors.As(err, &berr))
+	require.Equal(t, "retry budget exhausted: fail", err.Error())
+}
@@ -0,0 +1,52 @@
+package retry
+
+import "time"
+
+// PermanentError marks the error as not retryable, see Permanent.
+type PermanentError struct {
+	Err error
+}
+
+// Permanent wraps the err, so Retrier.Do stops retrying immediately,
+// nil returned if err is nil.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// AfterError hints the Retrier to retry after the Delay, see After.
+type AfterError struct {
+	Err   error
+	Delay time.Duration
+}
+
+// After wraps the err, so the next retrying waits for d instead of the backoff,
+// such as the Retry-After provided by the server, it still consumes a backoff,
+// so the limits of the Backoff are respected, e.g. no more retrying if d exceeds
+// the time left by WithMaxElapsed. nil returned if err is nil.
+func After(err error, d time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &AfterError{Err: err, Delay: d}
+}
+
+func (e *AfterError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *AfterError) Unwrap() error {
+	return e.Err
+}
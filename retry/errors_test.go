@@ -0,0 +1,112 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/damnever/goctl/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDo(t *testing.T) {
+	errFail := errors.New("fail")
+	r := NewWithOptions(Options{NewBackoff: func() Backoff { return SliceBackoff(ZeroBackoffs(3)) }})
+
+	cnt := 0
+	require.Nil(t, r.Do(context.TODO(), func() error {
+		if cnt++; cnt < 3 {
+			return errFail
+		}
+		return nil
+	}))
+	require.Equal(t, 3, cnt)
+
+	cnt = 0
+	require.Equal(t, errFail, r.Do(context.TODO(), func() error {
+		cnt++
+		return errFail
+	}))
+	require.Equal(t, 4, cnt)
+
+	cnt = 0
+	err := r.Do(context.TODO(), func() error {
+		cnt++
+		return fmt.Errorf("wrapped: %w", Permanent(errFail))
+	})
+	require.Equal(t, 1, cnt)
+	require.True(t, errors.Is(err, errFail))
+	require.Equal(t, "wrapped: fail", err.Error())
+	require.Nil(t, Permanent(nil))
+	require.Nil(t, After(nil, time.Second))
+
+	cnt = 0
+	r = NewWithOptions(Options{
+		NewBackoff: func() Backoff { return SliceBackoff(ZeroBackoffs(3)) },
+		Retryable:  func(err error) bool { return err != errFail },
+	})
+	require.Equal(t, errFail, r.Do(context.TODO(), func() error {
+		cnt++
+		return errFail
+	}))
+	require.Equal(t, 1, cnt)
+}
+
+func TestDoAfter(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	start := fake.Now()
+	var elapsed []time.Duration
+	r := NewWithOptions(Options{
+		NewBackoff: func() Backoff { return SliceBackoff(ConstantBackoffs(2, time.Second)) },
+		Clock:      fake,
+	})
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- r.Do(context.TODO(), func() error {
+			elapsed = append(elapsed, fake.Since(start))
+			return After(ErrNeedRetry, time.Minute)
+		})
+	}()
+	for i := 0; i < 2; i++ {
+		fake.BlockUntil(1)
+		fake.Add(time.Minute)
+	}
+	err := <-errc
+	require.True(t, errors.Is(err, ErrNeedRetry))
+	var aerr *AfterError
+	require.True(t, errors.As(err, &aerr))
+	require.Equal(t, time.Minute, aerr.Delay)
+	// The limits of the Backoff are respected.
+	require.Equal(t, []time.Duration{0, time.Minute, 2 * time.Minute}, elapsed)
+}
+
+func TestDoAfterWithMaxElapsed(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	r := NewWithOptions(Options{
+		NewBackoff: func() Backoff {
+			return WithMaxAttempts(WithMaxElapsed(Constant(time.Second), 2*time.Minute, fake), 10)
+		},
+		Clock: fake,
+	})
+
+	cnt := 0
+	errc := make(chan error, 1)
+	go func() {
+		errc <- r.Do(context.TODO(), func() error {
+			if cnt++; cnt == 1 {
+				return After(ErrNeedRetry, time.Minute)
+			}
+			return After(ErrNeedRetry, time.Hour)
+		})
+	}()
+	fake.BlockUntil(1)
+	fake.Add(time.Minute)
+	// The hinted hour exceeds the time left, no more waiting.
+	err := <-errc
+	require.True(t, errors.Is(err, ErrNeedRetry))
+	require.Equal(t, 2, cnt)
+	require.Equal(t, 0, fake.Waiters())
+}
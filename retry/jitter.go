@@ -0,0 +1,145 @@
+package retry
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// The jitter variants are from https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/,
+// they spread the retries of different clients, so the retry storms will not
+// be synchronized. For all of them, the max(if greater than 0) caps the backoffs,
+// and the rnd is the random source, nil means a source seeded by the current time,
+// use a rnd with a fixed seed for deterministic tests, note that the rand.Rand is
+// not goroutine safe.
+
+type fullJitterBackoff struct {
+	exponentialBackoff
+	rnd *rand.Rand
+}
+
+// FullJitter creates an unbounded Backoff with values are random in
+// [0, min(max, backoff*2^i)) for i in [0 1 2 ..].
+func FullJitter(backoff, max time.Duration, rnd *rand.Rand) Backoff {
+	return &fullJitterBackoff{
+		exponentialBackoff: exponentialBackoff{backoff: backoff, max: max},
+		rnd:                randOrNew(rnd),
+	}
+}
+
+func (b *fullJitterBackoff) Next() (time.Duration, bool) {
+	d, _ := b.exponentialBackoff.Next()
+	return randDuration(b.rnd, 0, d), true
+}
+
+type equalJitterBackoff struct {
+	exponentialBackoff
+	rnd *rand.Rand
+}
+
+// EqualJitter creates an unbounded Backoff with values are random in
+// [d/2, d) where d is min(max, backoff*2^i) for i in [0 1 2 ..],
+// it keeps some backoff against the full jitter.
+func EqualJitter(backoff, max time.Duration, rnd *rand.Rand) Backoff {
+	return &equalJitterBackoff{
+		exponentialBackoff: exponentialBackoff{backoff: backoff, max: max},
+		rnd:                randOrNew(rnd),
+	}
+}
+
+func (b *equalJitterBackoff) Next() (time.Duration, bool) {
+	d, _ := b.exponentialBackoff.Next()
+	return randDuration(b.rnd, d/2, d), true
+}
+
+type decorrelatedJitterBackoff struct {
+	backoff time.Duration
+	max     time.Duration
+	prev    time.Duration
+	rnd     *rand.Rand
+}
+
+// DecorrelatedJitter creates an unbounded Backoff with values are
+// min(max, random in [backoff, previous*3)), the first previous is backoff.
+func DecorrelatedJitter(backoff, max time.Duration, rnd *rand.Rand) Backoff {
+	return &decorrelatedJitterBackoff{
+		backoff: backoff,
+		max:     max,
+		prev:    backoff,
+		rnd:     randOrNew(rnd),
+	}
+}
+
+func (b *decorrelatedJitterBackoff) Next() (time.Duration, bool) {
+	upper := b.prev * 3
+	if upper < b.prev { // Overflow.
+		upper = math.MaxInt64
+	}
+	d := randDuration(b.rnd, b.backoff, upper)
+	if b.max > 0 && d > b.max {
+		d = b.max
+	}
+	b.prev = d
+	return d, true
+}
+
+func (b *decorrelatedJitterBackoff) Reset() {
+	b.prev = b.backoff
+}
+
+// FullJitterBackoffs creates a list of n backoffs by FullJitter.
+func FullJitterBackoffs(n int, backoff, max time.Duration, rnd *rand.Rand) []time.Duration {
+	return Durations(FullJitter(backoff, max, rnd), n)
+}
+
+// EqualJitterBackoffs creates a list of n backoffs by EqualJitter.
+func EqualJitterBackoffs(n int, backoff, max time.Duration, rnd *rand.Rand) []time.Duration {
+	return Durations(EqualJitter(backoff, max, rnd), n)
+}
+
+// DecorrelatedJitterBackoffs creates a list of n backoffs by DecorrelatedJitter.
+func DecorrelatedJitterBackoffs(n int, backoff, max time.Duration, rnd *rand.Rand) []time.Duration {
+	return Durations(DecorrelatedJitter(backoff, max, rnd), n)
+}
+
+// CappedExponentialBackoffs is like ExponentialBackoffs, but the values are capped by max.
+func CappedExponentialBackoffs(n int, backoff, max time.Duration) []time.Duration {
+	return Durations(Exponential(backoff, max), n)
+}
+
+// cappedExponential returns min(max, backoff*2^i) without overflow,
+// the max is ignored if it is not greater than 0.
+func cappedExponential(backoff, max time.Duration, i int) time.Duration {
+	if backoff <= 0 {
+		return 0
+	}
+	if max <= 0 {
+		max = math.MaxInt64
+	}
+	d := backoff
+	for ; i > 0 && d < max; i-- {
+		if d > max/2 {
+			return max
+		}
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// randDuration returns a random duration in [min, max), min returned if max <= min.
+func randDuration(rnd *rand.Rand, min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	return min + time.Duration(rnd.Int63n(int64(max-min)))
+}
+
+func randOrNew(rnd *rand.Rand) *rand.Rand {
+	if rnd == nil {
+		rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return rnd
+}
@@ -0,0 +1,49 @@
+package retry
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJitterBackoffs(t *testing.T) {
+	backoff, max := 10*time.Millisecond, 200*time.Millisecond
+	newRand := func() *rand.Rand { return rand.New(rand.NewSource(666)) }
+
+	backoffs := FullJitterBackoffs(10, backoff, max, newRand())
+	require.Equal(t, 10, len(backoffs))
+	for i, d := range backoffs {
+		require.True(t, d >= 0 && d < cappedExponential(backoff, max, i), "%d: %v", i, d)
+	}
+	require.Equal(t, backoffs, FullJitterBackoffs(10, backoff, max, newRand()))
+
+	backoffs = EqualJitterBackoffs(10, backoff, max, newRand())
+	for i, d := range backoffs {
+		upper := cappedExponential(backoff, max, i)
+		require.True(t, d >= upper/2 && d < upper, "%d: %v", i, d)
+	}
+	require.Equal(t, backoffs, EqualJitterBackoffs(10, backoff, max, newRand()))
+
+	backoffs = DecorrelatedJitterBackoffs(10, backoff, max, newRand())
+	prev := backoff
+	for i, d := range backoffs {
+		require.True(t, d >= backoff && d <= max && d < prev*3, "%d: %v", i, d)
+		prev = d
+	}
+	require.Equal(t, backoffs, DecorrelatedJitterBackoffs(10, backoff, max, newRand()))
+
+	// Different clients have different schedules.
+	require.NotEqual(t, FullJitterBackoffs(10, backoff, max, nil), FullJitterBackoffs(10, backoff, max, newRand()))
+	require.Equal(t, []time.Duration{0, 0}, FullJitterBackoffs(2, 0, max, nil))
+}
+
+func TestCappedExponentialBackoffs(t *testing.T) {
+	ms := time.Millisecond
+	require.Equal(t, []time.Duration{ms, 2 * ms, 4 * ms, 5 * ms, 5 * ms}, CappedExponentialBackoffs(5, ms, 5*ms))
+	require.Equal(t, ExponentialBackoffs(5, ms), CappedExponentialBackoffs(5, ms, 0))
+	require.Equal(t, time.Duration(math.MaxInt64), cappedExponential(ms, 0, 100))
+	require.Equal(t, time.Hour, cappedExponential(ms, time.Hour, 100))
+}
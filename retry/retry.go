@@ -5,6 +5,8 @@ import (
 	"context"
 	"errors"
 	"time"
+
+	"github.com/damnever/goctl/clock"
 )
 
 // ErrNeedRetry is a placholder helper, in case you have no error to return, such as bool status, etc.
@@ -23,9 +25,50 @@ const (
 	StopWithNil
 )
 
+// Attempt is the metadata of an attempt in RunAttempt.
+type Attempt struct {
+	// Number is the number of the attempt, starts from 1.
+	Number int
+	// Elapsed is the time elapsed since the first attempt started.
+	Elapsed time.Duration
+	// PrevErr is the error returned by the previous attempt, nil for the first attempt.
+	PrevErr error
+	// Ctx is the context of the attempt, it is canceled after Options.AttemptTimeout(if set).
+	Ctx context.Context
+}
+
+// Options configures the Retrier.
+type Options struct {
+	// NewBackoff creates the Backoff for each Run, nil means no retrying.
+	// Since the Backoff is stateful, it must return a new one on each call,
+	// so the Retrier is goroutine safe.
+	NewBackoff func() Backoff
+	// Clock is used to wait for the backoffs, nil means clock.New().
+	Clock clock.Clock
+	// Budget(if not nil) limits the retries, it can be shared between Retriers,
+	// Run returns a *BudgetExhaustedError immediately if the Budget is exhausted.
+	Budget *Budget
+	// AttemptTimeout is the timeout of Attempt.Ctx in RunAttempt, 0 means no timeout,
+	// it always uses the real time since it is driven by the context.
+	AttemptTimeout time.Duration
+	// OnRetry(if not nil) is called with the failed attempt before each backoff sleep,
+	// it is useful for logging and metrics, note that the Ctx of the attempt has been
+	// canceled if AttemptTimeout is set.
+	OnRetry func(attempt Attempt, err error, backoff time.Duration)
+	// Retryable classifies the error returned by the fn in Do, nil means all errors
+	// are retryable, the error wrapped by Permanent is never retryable.
+	Retryable func(err error) bool
+}
+
 // Retrier retrys fail actions with backoff.
 type Retrier struct {
-	backoffs []time.Duration
+	backoffs   []time.Duration
+	newBackoff func() Backoff
+	clock      clock.Clock
+	budget     *Budget
+	timeout    time.Duration
+	onRetry    func(attempt Attempt, err error, backoff time.Duration)
+	retryable  func(err error) bool
 }
 
 // New creates a new Retrier with backoffs, the backoffs is the wait
@@ -33,23 +76,70 @@ type Retrier struct {
 // The count of retrying will be len(backoffs), the first call
 // is not counted in retrying.
 func New(backoffs []time.Duration) Retrier {
-	return Retrier{backoffs: append(backoffs, 0)}
+	return NewWithClock(backoffs, clock.New())
+}
+
+// NewWithClock is like New, but waits for the backoffs by the timer of clk.
+func NewWithClock(backoffs []time.Duration, clk clock.Clock) Retrier {
+	return Retrier{backoffs: backoffs, clock: clk}
+}
+
+// NewWithOptions creates a new Retrier with opts, the unbounded or time-capped
+// retrying can be expressed by the Backoff.
+func NewWithOptions(opts Options) Retrier {
+	if opts.Clock == nil {
+		opts.Clock = clock.New()
+	}
+	return Retrier{
+		newBackoff: opts.NewBackoff,
+		clock:      opts.Clock,
+		budget:     opts.Budget,
+		timeout:    opts.AttemptTimeout,
+		onRetry:    opts.OnRetry,
+		retryable:  opts.Retryable,
+	}
+}
+
+func (r Retrier) backoff() Backoff {
+	if r.newBackoff == nil {
+		// The list is read-only, so it is goroutine safe.
+		return &sliceBackoff{backoffs: r.backoffs}
+	}
+	return r.newBackoff()
 }
 
 // Run keeps calling the RetryFunc if it returns (Continue, non-nil-err),
 // otherwise it will stop retrying. It is goroutine safe unless you do something wrong ^_^.
-func (r Retrier) Run(ctx context.Context, try func() (State, error)) (err error) {
+func (r Retrier) Run(ctx context.Context, try func() (State, error)) error {
+	return r.RunAttempt(ctx, func(Attempt) (State, error) { return try() })
+}
+
+// RunAttempt is like Run, but the try receives the metadata of the attempt,
+// the try should respect the Attempt.Ctx.
+func (r Retrier) RunAttempt(ctx context.Context, try func(attempt Attempt) (State, error)) (err error) {
 	var state State
 	cancelc := ctx.Done()
-	var timer *time.Timer
+	clk := r.clock
+	if clk == nil { // The zero Retrier.
+		clk = clock.New()
+	}
+	attempt := Attempt{}
+	start := clk.Now()
+	b := r.backoff()
+	if r.budget != nil {
+		r.budget.request()
+	}
+	var timer clock.Timer
 	defer func() {
 		if timer != nil {
 			timer.Stop()
 		}
 	}()
 
-	for _, backoff := range r.backoffs {
-		state, err = try()
+	for {
+		attempt.Number++
+		attempt.Elapsed = clk.Since(start)
+		state, err = r.try(ctx, &attempt, try)
 		switch state {
 		case StopWithErr:
 			return err
@@ -61,9 +151,28 @@ func (r Retrier) Run(ctx context.Context, try func() (State, error)) (err error)
 			return nil
 		}
 
+		backoff, ok := b.Next()
+		if !ok {
+			return err
+		}
+		var aerr *AfterError
+		if errors.As(err, &aerr) {
+			if !allows(b, aerr.Delay) {
+				return err
+			}
+			backoff = aerr.Delay
+		}
+		if r.budget != nil && !r.budget.withdraw() {
+			return &BudgetExhaustedError{Err: err}
+		}
+		if r.onRetry != nil {
+			r.onRetry(attempt, err, backoff)
+		}
+		attempt.PrevErr = err
+
 		if backoff > 0 {
 			if timer == nil {
-				timer = time.NewTimer(backoff)
+				timer = clk.NewTimer(backoff)
 			} else {
 				// It is safe to reset it since the channel explicitly drained.
 				timer.Reset(backoff)
@@ -71,7 +180,7 @@ func (r Retrier) Run(ctx context.Context, try func() (State, error)) (err error)
 			select {
 			case <-cancelc:
 				return ctx.Err()
-			case <-timer.C:
+			case <-timer.C():
 			}
 		} else {
 			select {
@@ -81,7 +190,33 @@ func (r Retrier) Run(ctx context.Context, try func() (State, error)) (err error)
 			}
 		}
 	}
-	return err
+}
+
+// Do is like Run, but the fn is retried if it returns a retryable error, which is
+// classified by Options.Retryable and Permanent, the delay hinted by After
+// overrides the backoff, but it is still limited by WithMaxElapsed. The error returned by fn is returned as is.
+func (r Retrier) Do(ctx context.Context, fn func() error) error {
+	return r.Run(ctx, func() (State, error) {
+		err := fn()
+		if err == nil {
+			return StopWithNil, nil
+		}
+		var perr *PermanentError
+		if errors.As(err, &perr) || (r.retryable != nil && !r.retryable(err)) {
+			return StopWithErr, err
+		}
+		return Continue, err
+	})
+}
+
+func (r Retrier) try(ctx context.Context, attempt *Attempt, try func(Attempt) (State, error)) (State, error) {
+	attempt.Ctx = ctx
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		attempt.Ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+	return try(*attempt)
 }
 
 // Retry is a shortcut for Retrier.Run with context.Background().
@@ -1,9 +1,11 @@
 package retry
 
 import (
+	"context"
 	"testing"
 	"time"
 
+	"github.com/damnever/goctl/clock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -59,6 +61,26 @@ func TestRetry(t *testing.T) {
 	}
 }
 
+func TestRetryWithClock(t *testing.T) {
+	start := time.Now()
+	fake := clock.NewFake(start)
+	var attempts []time.Duration
+	errc := make(chan error, 1)
+	go func() {
+		errc <- NewWithClock(ExponentialBackoffs(3, time.Second), fake).Run(context.TODO(), func() (State, error) {
+			attempts = append(attempts, fake.Since(start))
+			return Continue, ErrNeedRetry
+		})
+	}()
+
+	for _, backoff := range ExponentialBackoffs(3, time.Second) {
+		fake.BlockUntil(1)
+		fake.Add(backoff)
+	}
+	require.Equal(t, ErrNeedRetry, <-errc)
+	require.Equal(t, []time.Duration{0, time.Second, 3 * time.Second, 7 * time.Second}, attempts)
+}
+
 func TestBackoffFactory(t *testing.T) {
 	{
 		backoffs := ZeroBackoffs(3)
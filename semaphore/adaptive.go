@@ -0,0 +1,179 @@
+package semaphore
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Outcome is the outcome of a request, which is fed back to the AdaptiveSemaphore.
+type Outcome uint8
+
+const (
+	// Succeeded means the request succeeded, the RTT will be sampled.
+	Succeeded Outcome = iota
+	// Dropped means the request is dropped or timed out, which is a sign of overload.
+	Dropped
+	// Ignored means the request failed for reasons not related to the load,
+	// such as the bad requests, no sample will be taken.
+	Ignored
+)
+
+// AdaptiveOptions is used to configurate the AdaptiveSemaphore.
+type AdaptiveOptions struct {
+	// Algorithm estimates the limit, nil means NewVegasAlgorithm(1000).
+	Algorithm LimitAlgorithm
+	// InitialLimit is the initial limit, it must be in [MinLimit, MaxLimit].
+	InitialLimit int
+	// MinLimit is the lower bound of the limit, it must be greater than 0.
+	MinLimit int
+	// MaxLimit is the upper bound of the limit, 0 means no upper bound.
+	MaxLimit int
+}
+
+func (opts AdaptiveOptions) validate() error {
+	if opts.MinLimit <= 0 {
+		return errors.New("MinLimit must be greater than 0")
+	}
+	if opts.MaxLimit > 0 && opts.MaxLimit < opts.MinLimit {
+		return errors.New("MaxLimit must be greater than or equal to MinLimit")
+	}
+	if opts.InitialLimit < opts.MinLimit || (opts.MaxLimit > 0 && opts.InitialLimit > opts.MaxLimit) {
+		return errors.New("InitialLimit must be in [MinLimit, MaxLimit]")
+	}
+	return nil
+}
+
+type adaptiveWaiter struct {
+	inflight int
+	doneC    chan struct{}
+}
+
+// AdaptiveSemaphore is a kind of semaphore which adjusts the limit continuously
+// by the RTT and the outcome of requests, so there is no need to guess a fixed limit.
+type AdaptiveSemaphore struct {
+	opts AdaptiveOptions
+
+	l        sync.Mutex
+	limit    float64
+	inflight int
+	waiters  *list.List // FIFO list of *adaptiveWaiter
+}
+
+// NewAdaptiveSemaphore creates a new AdaptiveSemaphore.
+func NewAdaptiveSemaphore(opts AdaptiveOptions) (*AdaptiveSemaphore, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.Algorithm == nil {
+		opts.Algorithm = NewVegasAlgorithm(1000)
+	}
+	return &AdaptiveSemaphore{
+		opts:    opts,
+		limit:   float64(opts.InitialLimit),
+		waiters: list.New(),
+	}, nil
+}
+
+// Acquire acquires a Permit, blocks until ctx done.
+// The Permit must be released with the outcome of the request.
+func (s *AdaptiveSemaphore) Acquire(ctx context.Context) (*Permit, error) {
+	s.l.Lock()
+	if s.inflight < int(s.limit) && s.waiters.Len() == 0 {
+		s.inflight++
+		p := s.newPermit(s.inflight)
+		s.l.Unlock()
+		return p, nil
+	}
+
+	w := &adaptiveWaiter{doneC: make(chan struct{})}
+	elem := s.waiters.PushBack(w)
+	s.l.Unlock()
+
+	select {
+	case <-ctx.Done():
+		s.l.Lock()
+		defer s.l.Unlock()
+		select {
+		case <-w.doneC: // Double check.
+			return s.newPermit(w.inflight), nil // Must let user to release it.
+		default:
+			s.waiters.Remove(elem)
+		}
+		return nil, ctx.Err()
+	case <-w.doneC:
+		return s.newPermit(w.inflight), nil
+	}
+}
+
+func (s *AdaptiveSemaphore) newPermit(inflight int) *Permit {
+	return &Permit{
+		sem:      s,
+		inflight: inflight,
+		start:    time.Now(),
+	}
+}
+
+func (s *AdaptiveSemaphore) release(p *Permit, outcome Outcome) {
+	now := time.Now()
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.inflight--
+	if outcome != Ignored {
+		limit := s.opts.Algorithm.Update(s.limit, Sample{
+			RTT:      now.Sub(p.start),
+			Inflight: p.inflight,
+			Dropped:  outcome == Dropped,
+		})
+		limit = math.Max(limit, float64(s.opts.MinLimit))
+		if s.opts.MaxLimit > 0 {
+			limit = math.Min(limit, float64(s.opts.MaxLimit))
+		}
+		s.limit = limit
+	}
+
+	// Resume pendings, the limit may be increased.
+	for s.inflight < int(s.limit) && s.waiters.Len() > 0 {
+		w := s.waiters.Remove(s.waiters.Front()).(*adaptiveWaiter)
+		s.inflight++
+		w.inflight = s.inflight
+		close(w.doneC)
+	}
+}
+
+// Limit returns the current limit.
+func (s *AdaptiveSemaphore) Limit() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return int(s.limit)
+}
+
+// Inflight returns the number of inflight requests.
+func (s *AdaptiveSemaphore) Inflight() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.inflight
+}
+
+// Permit is acquired from the AdaptiveSemaphore for each request,
+// DO NOT reuse it.
+type Permit struct {
+	sem      *AdaptiveSemaphore
+	inflight int
+	start    time.Time
+	released int32
+}
+
+// Release releases the Permit and feeds the outcome back to the AdaptiveSemaphore.
+func (p *Permit) Release(outcome Outcome) error {
+	if !atomic.CompareAndSwapInt32(&p.released, 0, 1) {
+		return ErrOpMismatch
+	}
+	p.sem.release(p, outcome)
+	return nil
+}
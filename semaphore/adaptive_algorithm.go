@@ -0,0 +1,132 @@
+package semaphore
+
+import (
+	"math"
+	"time"
+)
+
+// Sample is the measurement of a finished request.
+type Sample struct {
+	// RTT is the round trip time of the request.
+	RTT time.Duration
+	// Inflight is the number of inflight requests when the request started.
+	Inflight int
+	// Dropped indicates the request is dropped or timed out, which is a sign of overload.
+	Dropped bool
+}
+
+// LimitAlgorithm estimates the concurrency limit by samples.
+// It is not required to be goroutine safe, the caller must serialize the calls.
+type LimitAlgorithm interface {
+	// Update returns the new limit by the current limit and a new sample,
+	// the result will be clamped by the caller.
+	Update(limit float64, sample Sample) float64
+}
+
+type vegasAlgorithm struct {
+	probeInterval int
+	nsample       int
+	rttNoLoad     time.Duration
+}
+
+// NewVegasAlgorithm creates a LimitAlgorithm inspired by TCP Vegas, it estimates
+// the queue size by limit*(1-rttNoLoad/rtt), the rttNoLoad is the min RTT ever seen.
+// The limit will be increased if the queue is small, decreased if the queue is large.
+//
+// Since the no-load RTT may change as the backend changes, the estimation
+// will be reset after every probeInterval samples, 0 means never reset.
+func NewVegasAlgorithm(probeInterval int) LimitAlgorithm {
+	return &vegasAlgorithm{probeInterval: probeInterval}
+}
+
+func (a *vegasAlgorithm) Update(limit float64, sample Sample) float64 {
+	logn := math.Max(1, math.Log10(limit))
+	if sample.Dropped {
+		return limit - logn
+	}
+	if sample.RTT <= 0 {
+		return limit
+	}
+
+	if a.probeInterval > 0 {
+		if a.nsample++; a.nsample >= a.probeInterval {
+			a.nsample = 0
+			a.rttNoLoad = 0
+		}
+	}
+	if a.rttNoLoad == 0 || sample.RTT < a.rttNoLoad {
+		a.rttNoLoad = sample.RTT
+		return limit
+	}
+	// The limit is not reached, the samples are meaningless.
+	if float64(sample.Inflight)*2 < limit {
+		return limit
+	}
+
+	queue := math.Ceil(limit * (1 - float64(a.rttNoLoad)/float64(sample.RTT)))
+	switch alpha, beta := 3*logn, 6*logn; {
+	case queue <= logn:
+		return limit + beta
+	case queue < alpha:
+		return limit + logn
+	case queue > beta:
+		return limit - logn
+	}
+	return limit
+}
+
+const gradientLongWindow = 600
+
+type gradientAlgorithm struct {
+	tolerance float64
+	smoothing float64
+	longRTT   float64
+}
+
+// NewGradientAlgorithm creates a LimitAlgorithm which adjusts the limit by
+// the gradient of the long term RTT(exponential moving average) and the
+// current RTT, the limit is reduced if the current RTT is greater than
+// the long term RTT multiplies tolerance, and it is halved(smoothed) on drops.
+//
+// The tolerance should be greater than or equal to 1, 1.5~2 is good enough,
+// the smoothing in (0, 1] controls how fast the limit changes.
+func NewGradientAlgorithm(tolerance, smoothing float64) LimitAlgorithm {
+	if tolerance < 1 {
+		tolerance = 1
+	}
+	if smoothing <= 0 || smoothing > 1 {
+		smoothing = 1
+	}
+	return &gradientAlgorithm{
+		tolerance: tolerance,
+		smoothing: smoothing,
+	}
+}
+
+func (a *gradientAlgorithm) Update(limit float64, sample Sample) float64 {
+	if sample.Dropped {
+		// A plain multiplicative decrease, the drop must never raise the limit.
+		return limit * (1 - 0.5*a.smoothing)
+	}
+
+	if sample.RTT <= 0 {
+		return limit
+	}
+	rtt := float64(sample.RTT)
+	if a.longRTT == 0 {
+		a.longRTT = rtt
+	} else {
+		a.longRTT += (rtt - a.longRTT) * 2 / (gradientLongWindow + 1)
+	}
+	// The limit is not reached, the samples are meaningless.
+	if float64(sample.Inflight)*2 < limit {
+		return limit
+	}
+	// The long term RTT is too high to recover from a burst, speed up the decay.
+	if a.longRTT/rtt > 2 {
+		a.longRTT *= 0.95
+	}
+	gradient := math.Max(0.5, math.Min(1, a.tolerance*a.longRTT/rtt))
+	newLimit := limit*gradient + math.Sqrt(limit)
+	return limit*(1-a.smoothing) + newLimit*a.smoothing
+}
@@ -0,0 +1,61 @@
+package semaphore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVegasAlgorithm(t *testing.T) {
+	a := NewVegasAlgorithm(0)
+	limit := 20.0
+	require.Equal(t, limit, a.Update(limit, Sample{RTT: 10 * time.Millisecond, Inflight: 20}))
+
+	// No queueing.
+	limit = a.Update(limit, Sample{RTT: 10 * time.Millisecond, Inflight: 20})
+	require.True(t, limit > 20)
+	// Not limited.
+	require.Equal(t, limit, a.Update(limit, Sample{RTT: 100 * time.Millisecond, Inflight: 1}))
+	// Heavy queueing.
+	prev := limit
+	limit = a.Update(limit, Sample{RTT: 100 * time.Millisecond, Inflight: 30})
+	require.True(t, limit < prev)
+	// Dropped.
+	prev = limit
+	limit = a.Update(limit, Sample{Dropped: true})
+	require.True(t, limit < prev)
+}
+
+func TestVegasAlgorithmProbe(t *testing.T) {
+	a := NewVegasAlgorithm(2).(*vegasAlgorithm)
+	a.Update(10, Sample{RTT: 10 * time.Millisecond, Inflight: 10})
+	require.Equal(t, 10*time.Millisecond, a.rttNoLoad)
+	a.Update(10, Sample{RTT: 20 * time.Millisecond, Inflight: 10})
+	require.Equal(t, 20*time.Millisecond, a.rttNoLoad)
+}
+
+func TestGradientAlgorithm(t *testing.T) {
+	a := NewGradientAlgorithm(1.5, 1)
+	limit := 16.0
+	for i := 0; i < 10; i++ {
+		limit = a.Update(limit, Sample{RTT: 10 * time.Millisecond, Inflight: int(limit)})
+	}
+	require.True(t, limit > 16)
+
+	prev := limit
+	for i := 0; i < 10; i++ {
+		limit = a.Update(limit, Sample{RTT: 100 * time.Millisecond, Inflight: int(limit)})
+	}
+	require.True(t, limit < prev)
+
+	prev = limit
+	require.True(t, a.Update(limit, Sample{Dropped: true}) < prev)
+
+	// The drop never raises the limit, even if it is small.
+	for _, limit := range []float64{1, 2, 3, 4} {
+		require.Equal(t, limit/2, a.Update(limit, Sample{Dropped: true}))
+	}
+	a = NewGradientAlgorithm(1.5, 0.5)
+	require.Equal(t, 1.5, a.Update(2, Sample{Dropped: true}))
+}
@@ -0,0 +1,81 @@
+package semaphore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fixedStepAlgorithm float64
+
+func (a fixedStepAlgorithm) Update(limit float64, sample Sample) float64 {
+	if sample.Dropped {
+		return limit - float64(a)
+	}
+	return limit + float64(a)
+}
+
+func TestAdaptiveOptions(t *testing.T) {
+	_, err := NewAdaptiveSemaphore(AdaptiveOptions{})
+	require.Contains(t, err.Error(), "MinLimit")
+	_, err = NewAdaptiveSemaphore(AdaptiveOptions{MinLimit: 3, MaxLimit: 2})
+	require.Contains(t, err.Error(), "MaxLimit")
+	_, err = NewAdaptiveSemaphore(AdaptiveOptions{MinLimit: 1, MaxLimit: 2, InitialLimit: 3})
+	require.Contains(t, err.Error(), "InitialLimit")
+	sem, err := NewAdaptiveSemaphore(AdaptiveOptions{MinLimit: 1, InitialLimit: 3})
+	require.Nil(t, err)
+	require.Equal(t, 3, sem.Limit())
+}
+
+func TestAdaptiveSemaphore(t *testing.T) {
+	ctx := context.TODO()
+	sem, err := NewAdaptiveSemaphore(AdaptiveOptions{
+		Algorithm:    fixedStepAlgorithm(1),
+		InitialLimit: 1,
+		MinLimit:     1,
+		MaxLimit:     2,
+	})
+	require.Nil(t, err)
+
+	p1, err := sem.Acquire(ctx)
+	require.Nil(t, err)
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = sem.Acquire(cctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	acquiredC := make(chan *Permit, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			p, err := sem.Acquire(ctx)
+			require.Nil(t, err)
+			acquiredC <- p
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, 1, sem.Inflight())
+
+	// The limit increased, both of the waiters get through.
+	require.Nil(t, p1.Release(Succeeded))
+	require.Equal(t, ErrOpMismatch, p1.Release(Succeeded))
+	p2, p3 := <-acquiredC, <-acquiredC
+	require.Equal(t, 2, sem.Limit())
+	require.Equal(t, 2, sem.Inflight())
+
+	require.Nil(t, p2.Release(Succeeded))
+	require.Equal(t, 2, sem.Limit()) // Max
+	require.Nil(t, p3.Release(Dropped))
+	require.Equal(t, 1, sem.Limit())
+	require.Equal(t, 0, sem.Inflight())
+
+	p4, err := sem.Acquire(ctx)
+	require.Nil(t, err)
+	require.Nil(t, p4.Release(Dropped))
+	require.Equal(t, 1, sem.Limit()) // Min
+	p5, err := sem.Acquire(ctx)
+	require.Nil(t, err)
+	require.Nil(t, p5.Release(Ignored))
+	require.Equal(t, 1, sem.Limit())
+}
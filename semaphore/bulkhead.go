@@ -0,0 +1,113 @@
+package semaphore
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrBulkheadFull is returned when the wait queue of Bulkhead is full,
+	// the request is rejected immediately.
+	ErrBulkheadFull = errors.New("bulkhead is full: too many waiters")
+	// ErrBulkheadTimeout is returned when the request waits in the queue of
+	// Bulkhead longer than the max queue wait.
+	ErrBulkheadTimeout = errors.New("bulkhead queue wait timed out")
+)
+
+// BulkheadStats is a snapshot of the statistics of a Bulkhead.
+type BulkheadStats struct {
+	Stats
+	// Rejected is the total number of requests rejected by the full queue.
+	Rejected uint64
+	// QueueTimeouts is the total number of requests waited longer than the max queue wait.
+	QueueTimeouts uint64
+}
+
+// Bulkhead is a kind of semaphore which limits both the concurrency and
+// the number of waiters, so overload will not turn into memory growth
+// and unbounded latency.
+type Bulkhead struct {
+	stats         stats
+	rejected      uint64
+	queueTimeouts uint64
+
+	maxQueue int64
+	maxWait  time.Duration
+	sem      chan struct{}
+}
+
+// NewBulkhead creates a new Bulkhead, the limit is the max concurrency,
+// the maxQueue is the max number of waiters, 0 means reject immediately
+// if limit reached, the maxWait is the max wait time in the queue, 0 means
+// wait until ctx done.
+func NewBulkhead(limit, maxQueue int, maxWait time.Duration) *Bulkhead {
+	return &Bulkhead{
+		maxQueue: int64(maxQueue),
+		maxWait:  maxWait,
+		sem:      make(chan struct{}, limit),
+	}
+}
+
+// Acquire acquires a semaphore, ErrBulkheadFull returned if the queue is full,
+// ErrBulkheadTimeout returned if wait too long, otherwise blocks until ctx done.
+func (b *Bulkhead) Acquire(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		err := ctx.Err()
+		b.stats.failed(err)
+		return err
+	case b.sem <- struct{}{}:
+		b.stats.acquired(0)
+		return nil
+	default:
+	}
+
+	// The waiters is also used to limit the queue length.
+	if atomic.AddInt64(&b.stats.waiters, 1) > b.maxQueue {
+		atomic.AddInt64(&b.stats.waiters, -1)
+		atomic.AddUint64(&b.rejected, 1)
+		return ErrBulkheadFull
+	}
+	start := time.Now()
+
+	var timeoutc <-chan time.Time
+	if b.maxWait > 0 {
+		timer := time.NewTimer(b.maxWait)
+		defer timer.Stop()
+		timeoutc = timer.C
+	}
+	select {
+	case <-ctx.Done():
+		err := ctx.Err()
+		b.stats.waitDone(start, err)
+		return err
+	case <-timeoutc:
+		atomic.AddInt64(&b.stats.waiters, -1)
+		atomic.AddUint64(&b.queueTimeouts, 1)
+		return ErrBulkheadTimeout
+	case b.sem <- struct{}{}:
+		b.stats.waitDone(start, nil)
+		return nil
+	}
+}
+
+// Release releases a semaphore.
+func (b *Bulkhead) Release() error {
+	select {
+	case <-b.sem:
+		return nil
+	default:
+		return ErrOpMismatch
+	}
+}
+
+// Stats returns a snapshot of the statistics.
+func (b *Bulkhead) Stats() BulkheadStats {
+	return BulkheadStats{
+		Stats:         b.stats.snapshot(len(b.sem)),
+		Rejected:      atomic.LoadUint64(&b.rejected),
+		QueueTimeouts: atomic.LoadUint64(&b.queueTimeouts),
+	}
+}
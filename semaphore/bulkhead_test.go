@@ -0,0 +1,54 @@
+package semaphore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkhead(t *testing.T) {
+	ctx := context.TODO()
+	b := NewBulkhead(1, 1, 20*time.Millisecond)
+	require.Nil(t, b.Acquire(ctx))
+
+	// Waits too long in the queue.
+	require.Equal(t, ErrBulkheadTimeout, b.Acquire(ctx))
+
+	acquired := make(chan struct{})
+	go func() {
+		require.Nil(t, b.Acquire(ctx))
+		close(acquired)
+	}()
+	time.Sleep(5 * time.Millisecond)
+	// The queue is full.
+	require.Equal(t, ErrBulkheadFull, b.Acquire(ctx))
+	require.Nil(t, b.Release())
+	<-acquired
+
+	cctx, cancel := context.WithTimeout(ctx, 5*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, b.Acquire(cctx))
+	require.Nil(t, b.Release())
+	require.Equal(t, ErrOpMismatch, b.Release())
+
+	stats := b.Stats()
+	require.Equal(t, 0, stats.Holders)
+	require.Equal(t, 0, stats.Waiters)
+	require.Equal(t, uint64(2), stats.Acquires)
+	require.Equal(t, uint64(1), stats.Timeouts)
+	require.Equal(t, uint64(1), stats.Rejected)
+	require.Equal(t, uint64(1), stats.QueueTimeouts)
+}
+
+func TestBulkheadNoQueue(t *testing.T) {
+	ctx := context.TODO()
+	b := NewBulkhead(2, 0, 0)
+	require.Nil(t, b.Acquire(ctx))
+	require.Nil(t, b.Acquire(ctx))
+	require.Equal(t, ErrBulkheadFull, b.Acquire(ctx))
+	require.Nil(t, b.Release())
+	require.Nil(t, b.Acquire(ctx))
+	require.Equal(t, uint64(1), b.Stats().Rejected)
+}
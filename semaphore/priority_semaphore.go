@@ -0,0 +1,133 @@
+package semaphore
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+type priorityWaiter struct {
+	seq       int64
+	priority  int
+	enqueueAt time.Time
+	doneC     chan struct{}
+}
+
+// PrioritySemaphore is a kind of semaphore which grants slots to the
+// waiter with the highest priority first, waiters with the same
+// priority are served in FIFO order.
+//
+// To prevent the low priority waiters from starving, the priority of
+// a waiter is increased by one for every aging interval it has waited.
+type PrioritySemaphore struct {
+	l       sync.Mutex
+	seq     int64
+	count   int
+	limit   int
+	aging   time.Duration
+	nwait   int
+	waiters map[int]*list.List // priority -> FIFO list of *priorityWaiter
+}
+
+// NewPrioritySemaphore creates a new PrioritySemaphore, aging is the interval
+// to increase the priority of waiters by one, 0 means no aging.
+func NewPrioritySemaphore(limit int, aging time.Duration) *PrioritySemaphore {
+	return &PrioritySemaphore{
+		seq:     0,
+		count:   0,
+		limit:   limit,
+		aging:   aging,
+		waiters: make(map[int]*list.List),
+	}
+}
+
+// Acquire acquires a semaphore with given priority, the bigger the number,
+// the higher the priority, blocks until ctx done.
+func (s *PrioritySemaphore) Acquire(ctx context.Context, priority int) error {
+	s.l.Lock()
+	if s.count < s.limit && s.nwait == 0 {
+		s.count++
+		s.l.Unlock()
+		return nil
+	}
+
+	s.seq++
+	w := &priorityWaiter{
+		seq:       s.seq,
+		priority:  priority,
+		enqueueAt: time.Now(),
+		doneC:     make(chan struct{}),
+	}
+	waiters, ok := s.waiters[priority]
+	if !ok {
+		waiters = list.New()
+		s.waiters[priority] = waiters
+	}
+	elem := waiters.PushBack(w)
+	s.nwait++
+	s.l.Unlock()
+
+	select {
+	case <-ctx.Done():
+		s.l.Lock()
+		defer s.l.Unlock()
+		select {
+		case <-w.doneC: // Double check.
+			return nil // Must let user to release it.
+		default:
+			s.remove(priority, waiters, elem)
+		}
+		return ctx.Err()
+	case <-w.doneC:
+		return nil
+	}
+}
+
+// Release releases a semaphore.
+func (s *PrioritySemaphore) Release() error {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if s.count <= 0 {
+		return ErrOpMismatch
+	}
+
+	var (
+		next     *list.Element
+		nextlist *list.List
+		nextprio int
+		best     int
+	)
+	now := time.Now()
+	for priority, waiters := range s.waiters {
+		// The head of the list always waits the longest in the same priority.
+		elem := waiters.Front()
+		w := elem.Value.(*priorityWaiter)
+		effective := w.priority
+		if s.aging > 0 {
+			effective += int(now.Sub(w.enqueueAt) / s.aging)
+		}
+		if next == nil || effective > best ||
+			(effective == best && w.seq < next.Value.(*priorityWaiter).seq) {
+			next, nextlist, nextprio, best = elem, waiters, priority, effective
+		}
+	}
+	if next == nil {
+		s.count--
+		return nil
+	}
+
+	// Hand over the slot to the waiter directly.
+	s.remove(nextprio, nextlist, next)
+	close(next.Value.(*priorityWaiter).doneC)
+	return nil
+}
+
+func (s *PrioritySemaphore) remove(priority int, waiters *list.List, elem *list.Element) {
+	waiters.Remove(elem)
+	if waiters.Len() == 0 {
+		delete(s.waiters, priority)
+	}
+	s.nwait--
+}
@@ -0,0 +1,76 @@
+package semaphore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrioritySemaphore(t *testing.T) {
+	ctx := context.TODO()
+	sem := NewPrioritySemaphore(1, 0)
+	require.Nil(t, sem.Acquire(ctx, 0))
+
+	orderC := make(chan int, 5)
+	for i, priority := range []int{1, 3, 2, 3, 1} {
+		go func(i, priority int) {
+			require.Nil(t, sem.Acquire(ctx, priority))
+			orderC <- i
+		}(i, priority)
+		time.Sleep(5 * time.Millisecond) // Keep the FIFO order.
+	}
+
+	for _, expect := range []int{1, 3, 2, 0, 4} {
+		require.Nil(t, sem.Release())
+		select {
+		case i := <-orderC:
+			require.Equal(t, expect, i)
+		case <-time.After(100 * time.Millisecond):
+			t.Fatalf("%d not acquired", expect)
+		}
+	}
+	require.Nil(t, sem.Release())
+	require.Equal(t, ErrOpMismatch, sem.Release())
+}
+
+func TestPrioritySemaphoreAging(t *testing.T) {
+	ctx := context.TODO()
+	aging := 10 * time.Millisecond
+	sem := NewPrioritySemaphore(1, aging)
+	require.Nil(t, sem.Acquire(ctx, 0))
+
+	orderC := make(chan int, 2)
+	go func() {
+		require.Nil(t, sem.Acquire(ctx, 0))
+		orderC <- 0
+	}()
+	time.Sleep(5 * aging)
+	go func() {
+		require.Nil(t, sem.Acquire(ctx, 2))
+		orderC <- 2
+	}()
+	time.Sleep(aging / 2)
+
+	require.Nil(t, sem.Release())
+	require.Equal(t, 0, <-orderC)
+	require.Nil(t, sem.Release())
+	require.Equal(t, 2, <-orderC)
+	require.Nil(t, sem.Release())
+}
+
+func TestPrioritySemaphoreCanceled(t *testing.T) {
+	sem := NewPrioritySemaphore(1, 0)
+	require.Nil(t, sem.Acquire(context.TODO(), 0))
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, sem.Acquire(ctx, 1))
+	require.Equal(t, 0, len(sem.waiters))
+
+	require.Nil(t, sem.Release())
+	require.Nil(t, sem.Acquire(context.TODO(), 0))
+	require.Nil(t, sem.Release())
+	require.Equal(t, ErrOpMismatch, sem.Release())
+}
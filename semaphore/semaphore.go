@@ -14,7 +14,8 @@ var (
 
 // Semaphore is a semaphore.
 type Semaphore struct {
-	sem chan struct{}
+	stats stats
+	sem   chan struct{}
 }
 
 // NewSemaphore creates a new Semaphore.
@@ -28,8 +29,23 @@ func NewSemaphore(limit int) *Semaphore {
 func (s *Semaphore) Acquire(ctx context.Context) error {
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
+		err := ctx.Err()
+		s.stats.failed(err)
+		return err
 	case s.sem <- struct{}{}:
+		s.stats.acquired(0)
+		return nil
+	default:
+	}
+
+	start := s.stats.waitStart()
+	select {
+	case <-ctx.Done():
+		err := ctx.Err()
+		s.stats.waitDone(start, err)
+		return err
+	case s.sem <- struct{}{}:
+		s.stats.waitDone(start, nil)
 		return nil
 	}
 }
@@ -43,3 +59,8 @@ func (s *Semaphore) Release() error {
 		return ErrOpMismatch
 	}
 }
+
+// Stats returns a snapshot of the statistics.
+func (s *Semaphore) Stats() Stats {
+	return s.stats.snapshot(len(s.sem))
+}
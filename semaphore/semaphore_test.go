@@ -30,3 +30,40 @@ func TestSemaphore(t *testing.T) {
 	require.Nil(t, sem.Release())
 	require.Equal(t, ErrOpMismatch, sem.Release())
 }
+
+func TestSemaphoreStats(t *testing.T) {
+	ctx := context.TODO()
+	sem := NewSemaphore(1)
+	require.Nil(t, sem.Acquire(ctx))
+
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, sem.Acquire(cctx))
+	cctx, cancel = context.WithCancel(ctx)
+	cancel()
+	require.Equal(t, context.Canceled, sem.Acquire(cctx))
+
+	acquired := make(chan struct{})
+	go func() {
+		require.Nil(t, sem.Acquire(ctx))
+		close(acquired)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	stats := sem.Stats()
+	require.Equal(t, 1, stats.Holders)
+	require.Equal(t, 1, stats.Waiters)
+	require.Nil(t, sem.Release())
+	<-acquired
+
+	stats = sem.Stats()
+	require.Equal(t, 1, stats.Holders)
+	require.Equal(t, 0, stats.Waiters)
+	require.Equal(t, uint64(2), stats.Acquires)
+	require.Equal(t, uint64(1), stats.Timeouts)
+	require.Equal(t, uint64(1), stats.Cancellations)
+	require.Equal(t, len(stats.WaitTime.Bounds)+1, len(stats.WaitTime.Counts))
+	require.Equal(t, uint64(1), stats.WaitTime.Counts[0])
+	require.Equal(t, uint64(1), stats.WaitTime.Counts[3]) // (10ms, 100ms]
+	// The waiter may start waiting a bit later than the sleep starts.
+	require.True(t, stats.WaitTime.Sum > 10*time.Millisecond, "%v", stats.WaitTime.Sum)
+}
@@ -0,0 +1,110 @@
+package semaphore
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+var waitTimeBounds = [...]time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// WaitHistogram is a histogram of the wait time of successful acquires.
+type WaitHistogram struct {
+	// Bounds are the inclusive upper bounds of buckets in ascending order.
+	Bounds []time.Duration
+	// Counts has len(Bounds)+1 buckets, Counts[i] is the number of wait time
+	// in (Bounds[i-1], Bounds[i]], the last one counts the rest.
+	Counts []uint64
+	// Sum is the sum of all wait time.
+	Sum time.Duration
+}
+
+// Stats is a snapshot of the statistics of a semaphore.
+type Stats struct {
+	// Holders is the number of current holders.
+	Holders int
+	// Waiters is the number of goroutines blocked in Acquire.
+	Waiters int
+	// Acquires is the total number of successful acquires.
+	Acquires uint64
+	// Timeouts is the total number of acquires failed due to ctx deadline exceeded.
+	Timeouts uint64
+	// Cancellations is the total number of acquires failed due to ctx canceled.
+	Cancellations uint64
+	// WaitTime is the histogram of the wait time of successful acquires.
+	WaitTime WaitHistogram
+}
+
+// stats only uses atomic operations, so it is cheap enough to be always on.
+// NOTE: keep it as the first field of the struct to ensure 64-bit alignment.
+type stats struct {
+	acquires      uint64
+	timeouts      uint64
+	cancellations uint64
+	waitSum       int64
+	waiters       int64
+	waitCounts    [len(waitTimeBounds) + 1]uint64
+}
+
+func (s *stats) acquired(wait time.Duration) {
+	atomic.AddUint64(&s.acquires, 1)
+	idx := len(waitTimeBounds)
+	for i, bound := range waitTimeBounds {
+		if wait <= bound {
+			idx = i
+			break
+		}
+	}
+	atomic.AddUint64(&s.waitCounts[idx], 1)
+	if wait > 0 {
+		atomic.AddInt64(&s.waitSum, int64(wait))
+	}
+}
+
+func (s *stats) waitStart() time.Time {
+	atomic.AddInt64(&s.waiters, 1)
+	return time.Now()
+}
+
+func (s *stats) waitDone(start time.Time, err error) {
+	atomic.AddInt64(&s.waiters, -1)
+	if err == nil {
+		s.acquired(time.Since(start))
+	} else {
+		s.failed(err)
+	}
+}
+
+func (s *stats) failed(err error) {
+	if err == context.DeadlineExceeded {
+		atomic.AddUint64(&s.timeouts, 1)
+	} else {
+		atomic.AddUint64(&s.cancellations, 1)
+	}
+}
+
+func (s *stats) snapshot(holders int) Stats {
+	counts := make([]uint64, len(s.waitCounts))
+	for i := range s.waitCounts {
+		counts[i] = atomic.LoadUint64(&s.waitCounts[i])
+	}
+	return Stats{
+		Holders:       holders,
+		Waiters:       int(atomic.LoadInt64(&s.waiters)),
+		Acquires:      atomic.LoadUint64(&s.acquires),
+		Timeouts:      atomic.LoadUint64(&s.timeouts),
+		Cancellations: atomic.LoadUint64(&s.cancellations),
+		WaitTime: WaitHistogram{
+			Bounds: append([]time.Duration(nil), waitTimeBounds[:]...),
+			Counts: counts,
+			Sum:    time.Duration(atomic.LoadInt64(&s.waitSum)),
+		},
+	}
+}
@@ -13,6 +13,7 @@ type tokenWrapper struct {
 // TokenizedSemaphore is a kind of semaphore which only allow
 // the same token acquire once until it released.
 type TokenizedSemaphore struct {
+	stats   stats
 	l       sync.Mutex
 	seq     int64
 	count   int
@@ -39,6 +40,7 @@ func (s *TokenizedSemaphore) Acquire(ctx context.Context, token string) error {
 		s.tokens[token] = true
 		s.count++
 		s.l.Unlock()
+		s.stats.acquired(0)
 		return nil
 	}
 
@@ -51,18 +53,23 @@ func (s *TokenizedSemaphore) Acquire(ctx context.Context, token string) error {
 	s.pending[seq] = td
 	s.l.Unlock()
 
+	start := s.stats.waitStart()
 	select {
 	case <-ctx.Done():
 		s.l.Lock()
 		defer s.l.Unlock()
 		select {
 		case <-td.doneC: // Double check.
+			s.stats.waitDone(start, nil)
 			return nil // Must let user to release it.
 		default:
 			delete(s.pending, seq)
 		}
-		return ctx.Err()
+		err := ctx.Err()
+		s.stats.waitDone(start, err)
+		return err
 	case <-td.doneC:
+		s.stats.waitDone(start, nil)
 		return nil
 	}
 }
@@ -92,3 +99,11 @@ func (s *TokenizedSemaphore) Release(token string) error {
 	}
 	return nil
 }
+
+// Stats returns a snapshot of the statistics.
+func (s *TokenizedSemaphore) Stats() Stats {
+	s.l.Lock()
+	holders := s.count
+	s.l.Unlock()
+	return s.stats.snapshot(holders)
+}
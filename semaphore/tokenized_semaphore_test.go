@@ -132,3 +132,30 @@ func TestTokenizedSemaphore3(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenizedSemaphoreStats(t *testing.T) {
+	ctx := context.TODO()
+	sem := NewTokenizedSemaphore(2)
+	require.Nil(t, sem.Acquire(ctx, "a"))
+
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, sem.Acquire(cctx, "a"))
+
+	acquired := make(chan struct{})
+	go func() {
+		require.Nil(t, sem.Acquire(ctx, "a"))
+		close(acquired)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, 1, sem.Stats().Waiters)
+	require.Nil(t, sem.Release("a"))
+	<-acquired
+
+	stats := sem.Stats()
+	require.Equal(t, 1, stats.Holders)
+	require.Equal(t, 0, stats.Waiters)
+	require.Equal(t, uint64(2), stats.Acquires)
+	require.Equal(t, uint64(1), stats.Timeouts)
+	require.Equal(t, uint64(0), stats.Cancellations)
+}